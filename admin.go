@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+    "encoding/json"
+    "net/http"
+    "path"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// AdminHandler is an http.Handler exposing administrative operations on a Limiter, so support engineers can
+// unblock a key without a deploy. Requests are routed on the last element of the URL path, so the handler can be
+// mounted under any prefix:
+//
+//	GET    .../quota?key=K            the key's Quota as JSON
+//	POST   .../reset?key=K            Reset the key's usage
+//	POST   .../grant?key=K&n=N&ttl=D  Grant N extra units for D, a duration such as 1h
+//	DELETE .../grant?key=K            Revoke the key's grant
+//
+// Successful changes respond with 204 No Content. Unknown operations respond with 404 Not Found, and operations
+// called with the wrong method with 405 Method Not Allowed and an Allow header listing the right ones. The handler
+// does no authentication of its own; mount it behind whatever protects other administrative endpoints.
+type AdminHandler struct {
+    limiter Limiter
+}
+
+// NewAdminHandler creates a new AdminHandler operating on the given limiter.
+func NewAdminHandler(limiter Limiter) *AdminHandler {
+    return &AdminHandler{limiter: limiter}
+}
+
+// adminMethods lists the methods accepted by each operation of an AdminHandler.
+var adminMethods = map[string][]string{
+    "quota": {http.MethodGet},
+    "reset": {http.MethodPost},
+    "grant": {http.MethodPost, http.MethodDelete},
+}
+
+// ServeHTTP implements http.Handler.
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    op := path.Base(r.URL.Path)
+    methods, ok := adminMethods[op]
+    if !ok {
+        http.NotFound(w, r)
+        return
+    }
+    if !allowed(methods, r.Method) {
+        w.Header().Set("Allow", strings.Join(methods, ", "))
+        http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+        return
+    }
+
+    query := r.URL.Query()
+    key := query.Get("key")
+    if key == "" {
+        http.Error(w, "missing key", http.StatusBadRequest)
+        return
+    }
+
+    ctx := r.Context()
+    switch {
+    case op == "quota":
+        quota, err := h.limiter.Quota(ctx, key)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(quota)
+
+    case op == "reset":
+        h.respond(w, h.limiter.Reset(ctx, key))
+
+    case op == "grant" && r.Method == http.MethodPost:
+        n, err := strconv.Atoi(query.Get("n"))
+        if err != nil || n <= 0 {
+            http.Error(w, "n must be a positive integer", http.StatusBadRequest)
+            return
+        }
+        ttl, err := time.ParseDuration(query.Get("ttl"))
+        if err != nil || ttl <= 0 {
+            http.Error(w, "ttl must be a positive duration", http.StatusBadRequest)
+            return
+        }
+        h.respond(w, h.limiter.Grant(ctx, key, n, ttl))
+
+    case op == "grant":
+        h.respond(w, h.limiter.Revoke(ctx, key))
+    }
+}
+
+// allowed reports whether method is one of methods.
+func allowed(methods []string, method string) bool {
+    for _, m := range methods {
+        if m == method {
+            return true
+        }
+    }
+    return false
+}
+
+// respond reports the outcome of a change.
+func (h *AdminHandler) respond(w http.ResponseWriter, err error) {
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,96 @@
+package ratelimit
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/umbeluzi/ratelimit/config"
+    "github.com/umbeluzi/ratelimit/storage"
+)
+
+func TestAdminHandler(t *testing.T) {
+    store := storage.NewInMemoryStorage()
+    defer store.Close()
+
+    limiter, err := New(FixedWindow, store, config.NewStatic(5, time.Minute, 2, 0, time.Now()))
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    ctx := context.Background()
+    limiter.AllowN(ctx, "test", 7)
+
+    handler := http.StripPrefix("/admin", NewAdminHandler(limiter))
+    serve := func(method, target string) *httptest.ResponseRecorder {
+        w := httptest.NewRecorder()
+        handler.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+        return w
+    }
+
+    if w := serve(http.MethodPost, "/admin/grant?key=test&n=1000&ttl=1h"); w.Code != http.StatusNoContent {
+        t.Fatalf("expected grant to succeed, got %d: %s", w.Code, w.Body)
+    }
+
+    w := serve(http.MethodGet, "/admin/quota?key=test")
+    if w.Code != http.StatusOK {
+        t.Fatalf("expected quota to succeed, got %d: %s", w.Code, w.Body)
+    }
+    var quota Quota
+    if err := json.NewDecoder(w.Body).Decode(&quota); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if quota.Used != 7 || quota.Granted != 1000 || quota.Remaining != 1000 {
+        t.Errorf("unexpected quota: %+v", quota)
+    }
+
+    if w := serve(http.MethodDelete, "/admin/grant?key=test"); w.Code != http.StatusNoContent {
+        t.Fatalf("expected revoke to succeed, got %d: %s", w.Code, w.Body)
+    }
+    if w := serve(http.MethodPost, "/admin/reset?key=test"); w.Code != http.StatusNoContent {
+        t.Fatalf("expected reset to succeed, got %d: %s", w.Code, w.Body)
+    }
+    if quota, _ := limiter.Quota(ctx, "test"); quota.Used != 0 || quota.Granted != 0 {
+        t.Errorf("expected a fresh key after revoking and resetting, got %+v", quota)
+    }
+}
+
+func TestAdminHandler_BadRequests(t *testing.T) {
+    store := storage.NewInMemoryStorage()
+    defer store.Close()
+
+    limiter, _ := New(FixedWindow, store, config.NewStatic(5, time.Minute, 2, 0, time.Now()))
+    handler := NewAdminHandler(limiter)
+
+    tests := []struct {
+        method string
+        target string
+        code   int
+    }{
+        {http.MethodPost, "/reset", http.StatusBadRequest},
+        {http.MethodPost, "/grant?key=test&n=0&ttl=1h", http.StatusBadRequest},
+        {http.MethodPost, "/grant?key=test&n=10&ttl=forever", http.StatusBadRequest},
+        {http.MethodGet, "/reset?key=test", http.StatusMethodNotAllowed},
+        {http.MethodGet, "/reset", http.StatusMethodNotAllowed},
+        {http.MethodPost, "/unknown?key=test", http.StatusNotFound},
+        {http.MethodGet, "/unknown", http.StatusNotFound},
+    }
+    for _, tt := range tests {
+        w := httptest.NewRecorder()
+        handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+        if w.Code != tt.code {
+            t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.target, tt.code, w.Code)
+        }
+    }
+
+    // Wrong methods are told which ones are accepted
+    for target, allow := range map[string]string{"/quota": "GET", "/reset": "POST", "/grant": "POST, DELETE"} {
+        w := httptest.NewRecorder()
+        handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, target+"?key=test", nil))
+        if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != allow {
+            t.Errorf("PUT %s: expected status 405 allowing %s, got %d allowing %q", target, allow, w.Code, w.Header().Get("Allow"))
+        }
+    }
+}
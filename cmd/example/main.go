@@ -3,7 +3,6 @@ package main
 import (
     "context"
     "fmt"
-    "sync"
     "time"
 
     "github.com/umbeluzi/ratelimit/config"
@@ -17,6 +16,7 @@ import (
 func main() {
     ctx := context.Background()
     storage := storage.NewInMemoryStorage()
+    defer storage.Close()
 
     // Example configuration with burst limit
     config := config.NewStatic(5, time.Minute, 2, 0, time.Now())
@@ -41,7 +41,6 @@ func main() {
 
     // Example using Token Bucket algorithm with burst support
     tokenBucket := tokenbucket.New(storage, config)
-    defer tokenBucket.Stop() // Ensure the ticker is stopped for graceful shutdown
     fmt.Println("Testing Token Bucket:")
     for i := 0; i < 10; i++ {
         allowed, err := tokenBucket.Allow(ctx, "tokenbucket_key")
@@ -5,11 +5,21 @@ import (
     "time"
 )
 
-// Config is the interface for rate limiter configuration.
-type Config interface {
+// Policy is the interface for the limits a rate limiter enforces. A policy only describes limits; the state of
+// each key, such as a token bucket's tokens, is kept by the limiter in its storage. So a policy can be shared by
+// any number of limiters, cached or loaded from a file.
+type Policy interface {
     MaxRequests(ctx context.Context) (int, error)
     Interval(ctx context.Context) (time.Duration, error)
     BurstLimit(ctx context.Context) (int, error)
+}
+
+// Config is the interface for rate limiter configuration, a Policy together with the state of a single token bucket.
+//
+// Deprecated: limiters only need a Policy and keep their state in storage, so the state methods are no longer
+// called. Config remains so that existing implementations keep compiling; implement Policy instead.
+type Config interface {
+    Policy
     Tokens(ctx context.Context) (int, error)
     SetTokens(ctx context.Context, tokens int) error
     LastRefill(ctx context.Context) (time.Time, error)
@@ -0,0 +1,108 @@
+package config
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "strconv"
+    "time"
+)
+
+// Names of the environment variables read by FromEnv.
+const (
+    EnvMaxRequests = "RATELIMIT_MAX_REQUESTS"
+    EnvInterval    = "RATELIMIT_INTERVAL"
+    EnvBurst       = "RATELIMIT_BURST"
+)
+
+// Names of the flags registered by RegisterFlags.
+const (
+    FlagMaxRequests = "ratelimit-max-requests"
+    FlagInterval    = "ratelimit-interval"
+    FlagBurst       = "ratelimit-burst"
+)
+
+// FromEnv returns defaults overridden by the environment variables that are set: RATELIMIT_MAX_REQUESTS and
+// RATELIMIT_BURST as integers, and RATELIMIT_INTERVAL as a duration such as "1m". Empty variables are treated as
+// unset. The resulting limits must be valid.
+func FromEnv(defaults Limits) (Limits, error) {
+    limits, err := fromEnv(defaults)
+    if err != nil {
+        return Limits{}, err
+    }
+    if err := limits.Validate(); err != nil {
+        return Limits{}, err
+    }
+    return limits, nil
+}
+
+// fromEnv overrides limits with the environment variables that are set, without validating the result.
+func fromEnv(limits Limits) (Limits, error) {
+    var err error
+    if value, ok := lookupEnv(EnvMaxRequests); ok {
+        if limits.Requests, err = strconv.Atoi(value); err != nil {
+            return Limits{}, fmt.Errorf("config: %s must be an integer, got %q", EnvMaxRequests, value)
+        }
+    }
+    if value, ok := lookupEnv(EnvInterval); ok {
+        if limits.Period, err = time.ParseDuration(value); err != nil {
+            return Limits{}, fmt.Errorf("config: %s must be a duration such as 1m, got %q", EnvInterval, value)
+        }
+    }
+    if value, ok := lookupEnv(EnvBurst); ok {
+        if limits.Burst, err = strconv.Atoi(value); err != nil {
+            return Limits{}, fmt.Errorf("config: %s must be an integer, got %q", EnvBurst, value)
+        }
+    }
+    return limits, nil
+}
+
+// lookupEnv returns the value of the environment variable name, reporting whether it is set and not empty.
+func lookupEnv(name string) (string, bool) {
+    value := os.Getenv(name)
+    return value, value != ""
+}
+
+// Flags holds the limits registered on a flag.FlagSet by RegisterFlags.
+type Flags struct {
+    fs          *flag.FlagSet
+    defaults    Limits
+    maxRequests int
+    interval    time.Duration
+    burst       int
+}
+
+// RegisterFlags registers -ratelimit-max-requests, -ratelimit-interval and -ratelimit-burst on fs, with the given
+// defaults. Call Limits once fs has been parsed.
+func RegisterFlags(fs *flag.FlagSet, defaults Limits) *Flags {
+    f := &Flags{fs: fs, defaults: defaults}
+    fs.IntVar(&f.maxRequests, FlagMaxRequests, defaults.Requests, "number of requests allowed per interval (env "+EnvMaxRequests+")")
+    fs.DurationVar(&f.interval, FlagInterval, defaults.Period, "interval requests are counted over (env "+EnvInterval+")")
+    fs.IntVar(&f.burst, FlagBurst, defaults.Burst, "number of requests allowed on top of the maximum (env "+EnvBurst+")")
+    return f
+}
+
+// Limits returns the configured limits. Flags set on the command line take precedence over environment variables,
+// which take precedence over the defaults given to RegisterFlags. The resulting limits must be valid.
+func (f *Flags) Limits() (Limits, error) {
+    limits, err := fromEnv(f.defaults)
+    if err != nil {
+        return Limits{}, err
+    }
+
+    f.fs.Visit(func(fl *flag.Flag) {
+        switch fl.Name {
+        case FlagMaxRequests:
+            limits.Requests = f.maxRequests
+        case FlagInterval:
+            limits.Period = f.interval
+        case FlagBurst:
+            limits.Burst = f.burst
+        }
+    })
+
+    if err := limits.Validate(); err != nil {
+        return Limits{}, err
+    }
+    return limits, nil
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+    "errors"
+    "flag"
+    "io"
+    "strings"
+    "testing"
+    "time"
+)
+
+var envDefaults = Limits{Requests: 5, Period: time.Minute, Burst: 2}
+
+func TestFromEnv(t *testing.T) {
+    limits, err := FromEnv(envDefaults)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if limits != envDefaults {
+        t.Errorf("expected the envDefaults without environment variables, got %+v", limits)
+    }
+
+    t.Setenv(EnvMaxRequests, "100")
+    t.Setenv(EnvInterval, "1h")
+    limits, err = FromEnv(envDefaults)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if want := (Limits{Requests: 100, Period: time.Hour, Burst: 2}); limits != want {
+        t.Errorf("expected %+v, got %+v", want, limits)
+    }
+
+    t.Setenv(EnvBurst, "-1")
+    if _, err := FromEnv(envDefaults); !errors.Is(err, ErrInvalidBurstLimit) {
+        t.Errorf("expected invalid limits to be rejected, got %v", err)
+    }
+
+    t.Setenv(EnvInterval, "hourly")
+    if _, err := FromEnv(envDefaults); err == nil || !strings.Contains(err.Error(), EnvInterval) {
+        t.Errorf("expected an error naming %s, got %v", EnvInterval, err)
+    }
+}
+
+func TestFromEnv_Empty(t *testing.T) {
+    t.Setenv(EnvMaxRequests, "")
+    t.Setenv(EnvInterval, "")
+    t.Setenv(EnvBurst, "")
+    limits, err := FromEnv(envDefaults)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if limits != envDefaults {
+        t.Errorf("expected empty variables to be treated as unset, got %+v", limits)
+    }
+}
+
+func TestFlags(t *testing.T) {
+    t.Setenv(EnvMaxRequests, "100")
+    t.Setenv(EnvInterval, "1h")
+
+    tests := []struct {
+        args []string
+        want Limits
+    }{
+        {nil, Limits{Requests: 100, Period: time.Hour, Burst: 2}},
+        {[]string{"-ratelimit-max-requests=10"}, Limits{Requests: 10, Period: time.Hour, Burst: 2}},
+        {[]string{"-ratelimit-interval=1s", "-ratelimit-burst=0"}, Limits{Requests: 100, Period: time.Second}},
+    }
+    for _, tt := range tests {
+        fs := flag.NewFlagSet("test", flag.ContinueOnError)
+        flags := RegisterFlags(fs, envDefaults)
+        if err := fs.Parse(tt.args); err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+
+        limits, err := flags.Limits()
+        if err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+        if limits != tt.want {
+            t.Errorf("%v: expected %+v, got %+v", tt.args, tt.want, limits)
+        }
+    }
+
+    fs := flag.NewFlagSet("test", flag.ContinueOnError)
+    fs.SetOutput(io.Discard)
+    flags := RegisterFlags(fs, envDefaults)
+    fs.Parse([]string{"-ratelimit-max-requests=0"})
+    if _, err := flags.Limits(); !errors.Is(err, ErrInvalidMaxRequests) {
+        t.Errorf("expected invalid limits to be rejected, got %v", err)
+    }
+}
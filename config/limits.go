@@ -0,0 +1,52 @@
+package config
+
+import (
+    "context"
+    "errors"
+    "time"
+)
+
+// Errors returned by Limits.Validate.
+var (
+    ErrInvalidMaxRequests = errors.New("config: max requests must be positive")
+    ErrInvalidInterval    = errors.New("config: interval must be positive")
+    ErrInvalidBurstLimit  = errors.New("config: burst limit must not be negative")
+)
+
+// Limits is an immutable Policy with fixed values. Being a plain value, it is safe to copy and share.
+type Limits struct {
+    // Requests is the number of requests allowed per Period.
+    Requests int
+    // Period is the interval Requests are counted over.
+    Period time.Duration
+    // Burst is the number of requests allowed on top of Requests.
+    Burst int
+}
+
+// Validate reports whether the limits can be enforced by every algorithm.
+func (l Limits) Validate() error {
+    switch {
+    case l.Requests <= 0:
+        return ErrInvalidMaxRequests
+    case l.Period <= 0:
+        return ErrInvalidInterval
+    case l.Burst < 0:
+        return ErrInvalidBurstLimit
+    }
+    return nil
+}
+
+// MaxRequests returns the number of requests allowed per interval.
+func (l Limits) MaxRequests(ctx context.Context) (int, error) {
+    return l.Requests, nil
+}
+
+// Interval returns the interval requests are counted over.
+func (l Limits) Interval(ctx context.Context) (time.Duration, error) {
+    return l.Period, nil
+}
+
+// BurstLimit returns the number of requests allowed on top of the maximum.
+func (l Limits) BurstLimit(ctx context.Context) (int, error) {
+    return l.Burst, nil
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+var _ Policy = Limits{}
+
+func TestLimits_Validate(t *testing.T) {
+    tests := []struct {
+        limits Limits
+        want   error
+    }{
+        {Limits{Requests: 5, Period: time.Minute, Burst: 2}, nil},
+        {Limits{Requests: 5, Period: time.Minute}, nil},
+        {Limits{Requests: 0, Period: time.Minute}, ErrInvalidMaxRequests},
+        {Limits{Requests: 5}, ErrInvalidInterval},
+        {Limits{Requests: 5, Period: time.Minute, Burst: -1}, ErrInvalidBurstLimit},
+    }
+    for _, tt := range tests {
+        if got := tt.limits.Validate(); got != tt.want {
+            t.Errorf("%+v: expected %v, got %v", tt.limits, tt.want, got)
+        }
+    }
+}
+
+func TestStatic_Limits(t *testing.T) {
+    var policy Policy = NewStatic(5, time.Minute, 2, 0, time.Now()).Limits()
+    ctx := context.Background()
+
+    maxRequests, _ := policy.MaxRequests(ctx)
+    interval, _ := policy.Interval(ctx)
+    burstLimit, _ := policy.BurstLimit(ctx)
+    if maxRequests != 5 || interval != time.Minute || burstLimit != 2 {
+        t.Errorf("expected (5, 1m, 2), got (%d, %s, %d)", maxRequests, interval, burstLimit)
+    }
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+    "context"
+    "errors"
+    "os"
+    "reflect"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// ErrNilPolicy is returned when a nil policy is given to a Reloadable.
+var ErrNilPolicy = errors.New("config: nil policy")
+
+// Validate reports whether p can be enforced. Policies with a Validate method, such as Limits and MapResolver, are
+// asked directly. Resolvers without one are accepted as they are, and the limits of other policies are checked with
+// Limits.Validate. Nil policies, including nil pointers such as (*Rules)(nil), are rejected with ErrNilPolicy.
+func Validate(p Policy) error {
+    if v := reflect.ValueOf(p); v.Kind() == reflect.Ptr && v.IsNil() {
+        return ErrNilPolicy
+    }
+
+    switch p := p.(type) {
+    case nil:
+        return ErrNilPolicy
+    case interface{ Validate() error }:
+        return p.Validate()
+    case Resolver:
+        return nil
+    }
+
+    limits, err := LimitsFor(context.Background(), p, "")
+    if err != nil {
+        return err
+    }
+    return limits.Validate()
+}
+
+// ReloadEvent describes an attempt to replace the policy of a Reloadable, for logging.
+type ReloadEvent struct {
+    // Source is the file the new policy was loaded from, or empty for a policy passed to Reload.
+    Source string
+    // Policy is the policy in effect after the attempt: the new one, or the previous one if the attempt failed.
+    Policy Policy
+    // Err is the reason the new policy was rejected, or nil if it was applied.
+    Err error
+}
+
+// Reloadable is a Policy whose underlying policy can be replaced while limiters use it, so limits can be changed
+// during an incident without restarting anything. A new policy is validated before it atomically replaces the
+// current one. Limiters read their policy on every decision, so they pick up the new limits on their next decision,
+// while the counters and state they keep in storage carry over.
+type Reloadable struct {
+    policy    atomic.Value // policyBox
+    mu        sync.Mutex   // serializes reloads and guards listeners
+    listeners []func(ReloadEvent)
+    stop      chan struct{}
+    closeOnce sync.Once
+}
+
+// policyBox lets policies of different types be stored in the same atomic.Value.
+type policyBox struct {
+    Policy
+}
+
+// NewReloadable creates a new Reloadable starting with the given policy, which must be valid.
+func NewReloadable(p Policy) (*Reloadable, error) {
+    if err := Validate(p); err != nil {
+        return nil, err
+    }
+    r := &Reloadable{stop: make(chan struct{})}
+    r.policy.Store(policyBox{p})
+    return r, nil
+}
+
+// WatchRules loads a rule file and watches it for changes, checking its modification time and size at the given
+// interval. Changed rules replace the current ones once they have been parsed and validated; if they are invalid,
+// the previous rules stay in effect. Call Close to stop watching.
+func WatchRules(name string, interval time.Duration) (*Reloadable, error) {
+    info, err := os.Stat(name)
+    if err != nil {
+        return nil, err
+    }
+    rules, err := LoadRules(name)
+    if err != nil {
+        return nil, err
+    }
+
+    r, err := NewReloadable(rules)
+    if err != nil {
+        return nil, err
+    }
+    if interval > 0 {
+        go r.watch(name, interval, info)
+    }
+    return r, nil
+}
+
+func (r *Reloadable) watch(name string, interval time.Duration, last os.FileInfo) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ticker.C:
+            info, err := os.Stat(name)
+            if err != nil {
+                // Report a vanished file once rather than on every tick
+                if last != nil {
+                    r.apply(name, nil, err)
+                }
+                last = nil
+                continue
+            }
+            if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+                continue
+            }
+            last = info
+
+            rules, err := LoadRules(name)
+            if err != nil {
+                r.apply(name, nil, err)
+                continue
+            }
+            r.apply(name, rules, nil)
+        case <-r.stop:
+            return
+        }
+    }
+}
+
+// Close stops watching the file of a Reloadable created by WatchRules. It is safe to call Close more than once.
+func (r *Reloadable) Close() error {
+    r.closeOnce.Do(func() {
+        close(r.stop)
+    })
+    return nil
+}
+
+// Reload validates p and, if it is valid, makes it the current policy. Otherwise the current policy is kept and
+// the validation error returned.
+func (r *Reloadable) Reload(p Policy) error {
+    return r.apply("", p, nil)
+}
+
+// apply replaces the current policy with p unless loading it failed with err or it is invalid, and reports the
+// attempt to the listeners.
+func (r *Reloadable) apply(source string, p Policy, err error) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if err == nil {
+        err = Validate(p)
+    }
+    if err == nil {
+        r.policy.Store(policyBox{p})
+    }
+
+    event := ReloadEvent{Source: source, Policy: r.Current(), Err: err}
+    for _, fn := range r.listeners {
+        fn(event)
+    }
+    return err
+}
+
+// OnReload registers fn to be called after every attempt to replace the policy, successful or not. Listeners are
+// called one at a time, in the order of the attempts, and must not call Reload.
+func (r *Reloadable) OnReload(fn func(ReloadEvent)) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.listeners = append(r.listeners, fn)
+}
+
+// Current returns the policy currently in effect.
+func (r *Reloadable) Current() Policy {
+    return r.policy.Load().(policyBox).Policy
+}
+
+// Resolve returns the limits the current policy applies to key, so that resolvers keep working when reloaded.
+func (r *Reloadable) Resolve(ctx context.Context, key string, attrs Attributes) (Limits, error) {
+    return LimitsFor(WithAttributes(ctx, attrs), r.Current(), key)
+}
+
+// MaxRequests returns the number of requests allowed per interval by the current policy.
+func (r *Reloadable) MaxRequests(ctx context.Context) (int, error) {
+    return r.Current().MaxRequests(ctx)
+}
+
+// Interval returns the interval requests are counted over by the current policy.
+func (r *Reloadable) Interval(ctx context.Context) (time.Duration, error) {
+    return r.Current().Interval(ctx)
+}
+
+// BurstLimit returns the number of requests allowed on top of the maximum by the current policy.
+func (r *Reloadable) BurstLimit(ctx context.Context) (int, error) {
+    return r.Current().BurstLimit(ctx)
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+    "context"
+    "errors"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+var _ Resolver = (*Reloadable)(nil)
+
+func TestValidate(t *testing.T) {
+    tests := []struct {
+        policy Policy
+        want   error
+    }{
+        {Limits{Requests: 5, Period: time.Minute}, nil},
+        {Limits{Requests: 5}, ErrInvalidInterval},
+        {NewStatic(0, time.Minute, 0, 0, time.Now()), ErrInvalidMaxRequests},
+        {MapResolver{Tiers: map[string]Limits{"pro": {Requests: 5, Period: time.Minute, Burst: -1}}}, ErrInvalidBurstLimit},
+        {ResolverFunc(nil), nil},
+        {nil, ErrNilPolicy},
+        {(*Rules)(nil), ErrNilPolicy},
+        {(*Static)(nil), ErrNilPolicy},
+    }
+    for _, tt := range tests {
+        if got := Validate(tt.policy); !errors.Is(got, tt.want) {
+            t.Errorf("Validate(%#v): expected %v, got %v", tt.policy, tt.want, got)
+        }
+    }
+}
+
+func TestReloadable_Reload(t *testing.T) {
+    r, err := NewReloadable(Limits{Requests: 5, Period: time.Minute})
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    var events []ReloadEvent
+    r.OnReload(func(event ReloadEvent) {
+        events = append(events, event)
+    })
+    ctx := context.Background()
+
+    if err := r.Reload(Limits{Requests: 10, Period: time.Minute}); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if maxRequests, _ := r.MaxRequests(ctx); maxRequests != 10 {
+        t.Errorf("expected the new policy to apply, got %d requests", maxRequests)
+    }
+
+    if err := r.Reload(Limits{Requests: -1, Period: time.Minute}); !errors.Is(err, ErrInvalidMaxRequests) {
+        t.Errorf("expected an invalid policy to be rejected, got %v", err)
+    }
+    if maxRequests, _ := r.MaxRequests(ctx); maxRequests != 10 {
+        t.Errorf("expected an invalid policy to keep the previous one, got %d requests", maxRequests)
+    }
+
+    if len(events) != 2 || events[0].Err != nil || events[1].Err == nil || events[1].Policy != (Limits{Requests: 10, Period: time.Minute}) {
+        t.Errorf("unexpected reload events: %+v", events)
+    }
+
+    if _, err := NewReloadable(Limits{}); err == nil {
+        t.Errorf("expected an invalid initial policy to be rejected")
+    }
+}
+
+func TestWatchRules(t *testing.T) {
+    name := filepath.Join(t.TempDir(), "rules.yaml")
+    write := func(limit string, at time.Time) {
+        t.Helper()
+        rules := "rules:\n  - key: \"*\"\n    algorithm: gcra\n    limit: " + limit + "\n    interval: 1m\n"
+        if err := os.WriteFile(name, []byte(rules), 0o600); err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+        // Set the modification time explicitly, as coarse file system clocks could miss the change
+        if err := os.Chtimes(name, at, at); err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+    }
+    now := time.Now()
+    write("5", now)
+
+    r, err := WatchRules(name, 5*time.Millisecond)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    defer r.Close()
+
+    events := make(chan ReloadEvent, 4)
+    r.OnReload(func(event ReloadEvent) {
+        events <- event
+    })
+    next := func() ReloadEvent {
+        t.Helper()
+        select {
+        case event := <-events:
+            return event
+        case <-time.After(time.Second):
+            t.Fatalf("expected a reload event")
+            return ReloadEvent{}
+        }
+    }
+    ctx := context.Background()
+
+    write("50", now.Add(time.Second))
+    if event := next(); event.Err != nil || event.Source != name {
+        t.Errorf("unexpected reload event: %+v", event)
+    }
+    if limits, _ := LimitsFor(ctx, r, "test"); limits.Requests != 50 {
+        t.Errorf("expected the changed rules to apply, got %+v", limits)
+    }
+
+    write("-1", now.Add(2*time.Second))
+    if event := next(); event.Err == nil {
+        t.Errorf("expected invalid rules to be reported, got %+v", event)
+    }
+    if limits, _ := LimitsFor(ctx, r, "test"); limits.Requests != 50 {
+        t.Errorf("expected invalid rules to keep the previous ones, got %+v", limits)
+    }
+}
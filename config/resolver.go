@@ -0,0 +1,157 @@
+package config
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sort"
+    "time"
+)
+
+// ErrNoLimits is returned by MapResolver when no limits apply to a key.
+var ErrNoLimits = errors.New("config: no limits for key")
+
+// Attributes describe a request beyond its key, such as the customer's plan, the route or the method.
+type Attributes map[string]string
+
+type attributesKey struct{}
+
+// WithAttributes returns a copy of ctx carrying attrs, which resolvers receive along with the key of each decision.
+func WithAttributes(ctx context.Context, attrs Attributes) context.Context {
+    return context.WithValue(ctx, attributesKey{}, attrs)
+}
+
+// AttributesFrom returns the attributes carried by ctx, or nil if there are none.
+func AttributesFrom(ctx context.Context) Attributes {
+    attrs, _ := ctx.Value(attributesKey{}).(Attributes)
+    return attrs
+}
+
+// Resolver is implemented by policies whose limits depend on the key being limited or on attributes of the request,
+// for example to give free, pro and enterprise customers different limits on the same endpoint. Limiters call
+// Resolve on every decision, with the decision's key and the attributes carried by its context.
+type Resolver interface {
+    Resolve(ctx context.Context, key string, attrs Attributes) (Limits, error)
+}
+
+// LimitsFor returns the limits p applies to key. If p is a Resolver, it resolves them with the attributes carried by
+// ctx; otherwise they are read from p's MaxRequests, Interval and BurstLimit.
+func LimitsFor(ctx context.Context, p Policy, key string) (Limits, error) {
+    if r, ok := p.(Resolver); ok {
+        return r.Resolve(ctx, key, AttributesFrom(ctx))
+    }
+
+    maxRequests, err := p.MaxRequests(ctx)
+    if err != nil {
+        return Limits{}, err
+    }
+
+    interval, err := p.Interval(ctx)
+    if err != nil {
+        return Limits{}, err
+    }
+
+    burstLimit, err := p.BurstLimit(ctx)
+    if err != nil {
+        return Limits{}, err
+    }
+
+    return Limits{Requests: maxRequests, Period: interval, Burst: burstLimit}, nil
+}
+
+// ResolverFunc is a Resolver calling a function. It also implements Policy, so it can be passed to any limiter, and
+// it adapts a custom Resolver as ResolverFunc(r.Resolve). Its Policy methods resolve the limits of the empty key.
+type ResolverFunc func(ctx context.Context, key string, attrs Attributes) (Limits, error)
+
+// Resolve calls f.
+func (f ResolverFunc) Resolve(ctx context.Context, key string, attrs Attributes) (Limits, error) {
+    return f(ctx, key, attrs)
+}
+
+// MaxRequests returns the number of requests allowed per interval for the empty key.
+func (f ResolverFunc) MaxRequests(ctx context.Context) (int, error) {
+    limits, err := f(ctx, "", AttributesFrom(ctx))
+    return limits.Requests, err
+}
+
+// Interval returns the interval requests are counted over for the empty key.
+func (f ResolverFunc) Interval(ctx context.Context) (time.Duration, error) {
+    limits, err := f(ctx, "", AttributesFrom(ctx))
+    return limits.Period, err
+}
+
+// BurstLimit returns the number of requests allowed on top of the maximum for the empty key.
+func (f ResolverFunc) BurstLimit(ctx context.Context) (int, error) {
+    limits, err := f(ctx, "", AttributesFrom(ctx))
+    return limits.Burst, err
+}
+
+// MapResolver is a Resolver with a fixed set of limits, chosen by key or by the value of one attribute, such as the
+// customer's plan. Limits for the key take precedence over limits for the attribute, and Default applies when
+// neither matches. MapResolver also implements Policy; its Policy methods resolve the limits of the empty key.
+type MapResolver struct {
+    // Keys holds the limits of individual keys.
+    Keys map[string]Limits
+    // Attribute names the attribute selecting from Tiers, for example "plan".
+    Attribute string
+    // Tiers holds limits by value of Attribute.
+    Tiers map[string]Limits
+    // Default applies to keys matching neither Keys nor Tiers. If it is zero, such keys get ErrNoLimits.
+    Default Limits
+}
+
+// Resolve returns the limits applying to key.
+func (m MapResolver) Resolve(ctx context.Context, key string, attrs Attributes) (Limits, error) {
+    if limits, ok := m.Keys[key]; ok {
+        return limits, nil
+    }
+    if value, ok := attrs[m.Attribute]; ok && m.Attribute != "" {
+        if limits, ok := m.Tiers[value]; ok {
+            return limits, nil
+        }
+    }
+    if m.Default == (Limits{}) {
+        return Limits{}, fmt.Errorf("%w %q", ErrNoLimits, key)
+    }
+    return m.Default, nil
+}
+
+// Validate reports whether all of the resolver's limits can be enforced.
+func (m MapResolver) Validate() error {
+    for _, set := range []struct {
+        name   string
+        limits map[string]Limits
+    }{{"key", m.Keys}, {"tier", m.Tiers}} {
+        names := make([]string, 0, len(set.limits))
+        for name := range set.limits {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+        for _, name := range names {
+            if err := set.limits[name].Validate(); err != nil {
+                return fmt.Errorf("%s %q: %w", set.name, name, err)
+            }
+        }
+    }
+    if m.Default != (Limits{}) {
+        if err := m.Default.Validate(); err != nil {
+            return fmt.Errorf("default: %w", err)
+        }
+    }
+    return nil
+}
+
+// MaxRequests returns the number of requests allowed per interval for the empty key.
+func (m MapResolver) MaxRequests(ctx context.Context) (int, error) {
+    return ResolverFunc(m.Resolve).MaxRequests(ctx)
+}
+
+// Interval returns the interval requests are counted over for the empty key.
+func (m MapResolver) Interval(ctx context.Context) (time.Duration, error) {
+    return ResolverFunc(m.Resolve).Interval(ctx)
+}
+
+// BurstLimit returns the number of requests allowed on top of the maximum for the empty key.
+func (m MapResolver) BurstLimit(ctx context.Context) (int, error) {
+    return ResolverFunc(m.Resolve).BurstLimit(ctx)
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+)
+
+var (
+    _ Policy   = ResolverFunc(nil)
+    _ Resolver = ResolverFunc(nil)
+    _ Policy   = MapResolver{}
+    _ Resolver = MapResolver{}
+)
+
+var (
+    free       = Limits{Requests: 2, Period: time.Minute}
+    pro        = Limits{Requests: 5, Period: time.Minute, Burst: 2}
+    enterprise = Limits{Requests: 100, Period: time.Second}
+)
+
+func TestMapResolver(t *testing.T) {
+    resolver := MapResolver{
+        Keys:      map[string]Limits{"acme": enterprise},
+        Attribute: "plan",
+        Tiers:     map[string]Limits{"pro": pro},
+        Default:   free,
+    }
+    ctx := context.Background()
+
+    tests := []struct {
+        key   string
+        attrs Attributes
+        want  Limits
+    }{
+        {"acme", Attributes{"plan": "pro"}, enterprise},
+        {"initech", Attributes{"plan": "pro"}, pro},
+        {"initech", Attributes{"plan": "unknown"}, free},
+        {"initech", nil, free},
+    }
+    for _, tt := range tests {
+        got, err := resolver.Resolve(ctx, tt.key, tt.attrs)
+        if err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+        if got != tt.want {
+            t.Errorf("Resolve(%q, %v) = %+v, expected %+v", tt.key, tt.attrs, got, tt.want)
+        }
+    }
+
+    resolver.Default = Limits{}
+    if _, err := resolver.Resolve(ctx, "initech", nil); !errors.Is(err, ErrNoLimits) {
+        t.Errorf("expected ErrNoLimits without a default, got %v", err)
+    }
+}
+
+func TestLimitsFor(t *testing.T) {
+    resolver := ResolverFunc(func(ctx context.Context, key string, attrs Attributes) (Limits, error) {
+        if attrs["plan"] == "pro" {
+            return pro, nil
+        }
+        return free, nil
+    })
+
+    ctx := WithAttributes(context.Background(), Attributes{"plan": "pro"})
+    if got, _ := LimitsFor(ctx, resolver, "test"); got != pro {
+        t.Errorf("expected the attributes carried by ctx to be resolved, got %+v", got)
+    }
+    if got, _ := LimitsFor(context.Background(), resolver, "test"); got != free {
+        t.Errorf("expected limits without attributes, got %+v", got)
+    }
+
+    // The Policy methods resolve the limits of the empty key
+    if maxRequests, _ := resolver.MaxRequests(ctx); maxRequests != pro.Requests {
+        t.Errorf("expected MaxRequests %d, got %d", pro.Requests, maxRequests)
+    }
+
+    // Policies that are not resolvers are read through their methods
+    got, err := LimitsFor(ctx, NewStatic(5, time.Minute, 2, 0, time.Now()), "test")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if got != pro {
+        t.Errorf("expected %+v, got %+v", pro, got)
+    }
+}
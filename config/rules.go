@@ -0,0 +1,281 @@
+package config
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "path"
+    "strconv"
+    "strings"
+    "time"
+
+    "gopkg.in/yaml.v3"
+)
+
+// Rule is a single rule of a rule file: the algorithm and limits applying to keys matching a pattern.
+type Rule struct {
+    // Key is the pattern of the keys the rule applies to, in path.Match syntax, for example "api:*". As in paths,
+    // * does not match a slash.
+    Key string
+    // Algorithm is the name of the algorithm enforcing the rule, one of Algorithms. ratelimit.NewRouter limits each
+    // key with the algorithm of its rule; other limiters given Rules as their policy only take the limits.
+    Algorithm string
+    // Limits are the limits the rule enforces.
+    Limits Limits
+    // Line is the line the rule starts at in its file.
+    Line int
+}
+
+// Algorithms lists the algorithm names a rule may use. They match the Name constants of the algorithm packages.
+var Algorithms = []string{"fixedwindow", "gcra", "leakybucket", "slidinglog", "slidingwindow", "tokenbucket"}
+
+// RuleError describes a problem with a rule file, at the line it was found.
+type RuleError struct {
+    Line int
+    Err  error
+}
+
+// Error implements error.
+func (e *RuleError) Error() string {
+    return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *RuleError) Unwrap() error {
+    return e.Err
+}
+
+// RuleErrors lists every problem found in a rule file.
+type RuleErrors []*RuleError
+
+// Error implements error.
+func (e RuleErrors) Error() string {
+    messages := make([]string, len(e))
+    for i, err := range e {
+        messages[i] = err.Error()
+    }
+    return "config: invalid rules: " + strings.Join(messages, "; ")
+}
+
+// Rules is a Policy loaded from a YAML or JSON rule file such as:
+//
+//	rules:
+//	  - key: "search:*"
+//	    algorithm: gcra
+//	    limit: 100
+//	    interval: 1m
+//	    burst: 20
+//	  - key: "*"
+//	    algorithm: fixedwindow
+//	    limit: 10
+//	    interval: 1s
+//
+// A key is limited by the first rule whose pattern matches it. Rules is immutable and implements Resolver, so it can
+// be passed to any limiter; its Policy methods resolve the limits of the empty key.
+type Rules struct {
+    rules []Rule
+}
+
+// LoadRules reads a rule file. See ParseRules.
+func LoadRules(name string) (*Rules, error) {
+    data, err := os.ReadFile(name)
+    if err != nil {
+        return nil, err
+    }
+    rules, err := ParseRules(data)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", name, err)
+    }
+    return rules, nil
+}
+
+// ParseRules parses a YAML or JSON rule set. Every rule needs a key pattern, an algorithm, a positive limit and a
+// positive interval, written as a duration such as "1m"; the burst defaults to zero. Unknown fields and algorithms
+// are rejected.
+// If the rules are invalid, the returned error is a RuleErrors listing each problem with its line number.
+func ParseRules(data []byte) (*Rules, error) {
+    var root yaml.Node
+    if err := yaml.Unmarshal(data, &root); err != nil {
+        return nil, fmt.Errorf("config: invalid rules: %w", err)
+    }
+
+    p := &ruleParser{}
+    if len(root.Content) == 0 {
+        p.fail(1, "no rules")
+        return nil, p.errs
+    }
+    rules := p.parseFile(root.Content[0])
+    if len(p.errs) > 0 {
+        return nil, p.errs
+    }
+    return &Rules{rules: rules}, nil
+}
+
+// ruleParser walks the nodes of a rule file, collecting errors as it goes.
+type ruleParser struct {
+    errs RuleErrors
+}
+
+func (p *ruleParser) fail(line int, format string, args ...interface{}) {
+    p.errs = append(p.errs, &RuleError{Line: line, Err: fmt.Errorf(format, args...)})
+}
+
+// fields returns the values of a mapping node by field name, reporting unknown and duplicate fields.
+func (p *ruleParser) fields(node *yaml.Node, known ...string) map[string]*yaml.Node {
+    if node.Kind != yaml.MappingNode {
+        p.fail(node.Line, "expected a mapping")
+        return nil
+    }
+
+    fields := make(map[string]*yaml.Node)
+    for i := 0; i+1 < len(node.Content); i += 2 {
+        name, value := node.Content[i], node.Content[i+1]
+        switch {
+        case !contains(known, name.Value):
+            p.fail(name.Line, "unknown field %q", name.Value)
+        case fields[name.Value] != nil:
+            p.fail(name.Line, "duplicate field %q", name.Value)
+        default:
+            fields[name.Value] = value
+        }
+    }
+    return fields
+}
+
+func (p *ruleParser) parseFile(node *yaml.Node) []Rule {
+    fields := p.fields(node, "rules")
+    list, ok := fields["rules"]
+    if !ok {
+        if fields != nil {
+            p.fail(node.Line, "missing field %q", "rules")
+        }
+        return nil
+    }
+    if list.Kind != yaml.SequenceNode {
+        p.fail(list.Line, "rules must be a list")
+        return nil
+    }
+
+    rules := make([]Rule, 0, len(list.Content))
+    for _, item := range list.Content {
+        if rule, ok := p.parseRule(item); ok {
+            rules = append(rules, rule)
+        }
+    }
+    return rules
+}
+
+func (p *ruleParser) parseRule(node *yaml.Node) (Rule, bool) {
+    errs := len(p.errs)
+    fields := p.fields(node, "key", "algorithm", "limit", "interval", "burst")
+    if fields == nil {
+        return Rule{}, false
+    }
+
+    rule := Rule{Line: node.Line}
+    for _, name := range []string{"key", "algorithm", "limit", "interval"} {
+        if fields[name] == nil {
+            p.fail(node.Line, "missing field %q", name)
+        }
+    }
+
+    if value := fields["key"]; value != nil {
+        rule.Key = value.Value
+        if _, err := path.Match(rule.Key, ""); err != nil || rule.Key == "" {
+            p.fail(value.Line, "invalid key pattern %q", rule.Key)
+        }
+    }
+    if value := fields["algorithm"]; value != nil {
+        rule.Algorithm = value.Value
+        switch {
+        case rule.Algorithm == "":
+            p.fail(value.Line, "algorithm must not be empty")
+        case !contains(Algorithms, rule.Algorithm):
+            p.fail(value.Line, "unknown algorithm %q", rule.Algorithm)
+        }
+    }
+    if value := fields["limit"]; value != nil {
+        var ok bool
+        rule.Limits.Requests, ok = p.parseInt(value, "limit")
+        if ok && rule.Limits.Requests <= 0 {
+            p.fail(value.Line, "limit must be positive, got %s", value.Value)
+        }
+    }
+    if value := fields["interval"]; value != nil {
+        interval, err := time.ParseDuration(value.Value)
+        switch {
+        case err != nil:
+            p.fail(value.Line, "interval must be a duration such as 1m, got %q", value.Value)
+        case interval <= 0:
+            p.fail(value.Line, "interval must be positive, got %s", value.Value)
+        }
+        rule.Limits.Period = interval
+    }
+    if value := fields["burst"]; value != nil {
+        var ok bool
+        rule.Limits.Burst, ok = p.parseInt(value, "burst")
+        if ok && rule.Limits.Burst < 0 {
+            p.fail(value.Line, "burst must not be negative, got %s", value.Value)
+        }
+    }
+
+    return rule, len(p.errs) == errs
+}
+
+// parseInt parses an integer scalar, reporting anything else.
+func (p *ruleParser) parseInt(node *yaml.Node, name string) (int, bool) {
+    n, err := strconv.Atoi(node.Value)
+    if node.Kind != yaml.ScalarNode || err != nil {
+        p.fail(node.Line, "%s must be an integer, got %q", name, node.Value)
+        return 0, false
+    }
+    return n, true
+}
+
+func contains(names []string, name string) bool {
+    for _, n := range names {
+        if n == name {
+            return true
+        }
+    }
+    return false
+}
+
+// Rules returns the rules in file order.
+func (r *Rules) Rules() []Rule {
+    return append([]Rule(nil), r.rules...)
+}
+
+// Match returns the first rule whose pattern matches key.
+func (r *Rules) Match(key string) (Rule, bool) {
+    for _, rule := range r.rules {
+        if ok, _ := path.Match(rule.Key, key); ok {
+            return rule, true
+        }
+    }
+    return Rule{}, false
+}
+
+// Resolve returns the limits of the first rule matching key, or ErrNoLimits if none matches.
+func (r *Rules) Resolve(ctx context.Context, key string, attrs Attributes) (Limits, error) {
+    rule, ok := r.Match(key)
+    if !ok {
+        return Limits{}, fmt.Errorf("%w %q", ErrNoLimits, key)
+    }
+    return rule.Limits, nil
+}
+
+// MaxRequests returns the number of requests allowed per interval for the empty key.
+func (r *Rules) MaxRequests(ctx context.Context) (int, error) {
+    return ResolverFunc(r.Resolve).MaxRequests(ctx)
+}
+
+// Interval returns the interval requests are counted over for the empty key.
+func (r *Rules) Interval(ctx context.Context) (time.Duration, error) {
+    return ResolverFunc(r.Resolve).Interval(ctx)
+}
+
+// BurstLimit returns the number of requests allowed on top of the maximum for the empty key.
+func (r *Rules) BurstLimit(ctx context.Context) (int, error) {
+    return ResolverFunc(r.Resolve).BurstLimit(ctx)
+}
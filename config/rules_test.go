@@ -0,0 +1,141 @@
+package config
+
+import (
+    "context"
+    "errors"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+var _ Resolver = (*Rules)(nil)
+
+const testRules = `
+rules:
+  - key: "search:*"
+    algorithm: gcra
+    limit: 100
+    interval: 1m
+    burst: 20
+  - key: "*"
+    algorithm: fixedwindow
+    limit: 10
+    interval: 1s
+`
+
+func TestParseRules(t *testing.T) {
+    rules, err := ParseRules([]byte(testRules))
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    want := []Rule{
+        {Key: "search:*", Algorithm: "gcra", Limits: Limits{Requests: 100, Period: time.Minute, Burst: 20}, Line: 3},
+        {Key: "*", Algorithm: "fixedwindow", Limits: Limits{Requests: 10, Period: time.Second}, Line: 8},
+    }
+    got := rules.Rules()
+    if len(got) != len(want) {
+        t.Fatalf("expected %d rules, got %d", len(want), len(got))
+    }
+    for i := range want {
+        if got[i] != want[i] {
+            t.Errorf("rule %d: expected %+v, got %+v", i, want[i], got[i])
+        }
+    }
+}
+
+func TestParseRules_JSON(t *testing.T) {
+    rules, err := ParseRules([]byte(`{
+  "rules": [
+    {"key": "login:*", "algorithm": "slidinglog", "limit": 5, "interval": "15m"}
+  ]
+}`))
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    rule, ok := rules.Match("login:alice")
+    if !ok || rule.Algorithm != "slidinglog" || rule.Limits != (Limits{Requests: 5, Period: 15 * time.Minute}) || rule.Line != 3 {
+        t.Errorf("unexpected rule: %+v", rule)
+    }
+}
+
+func TestParseRules_Invalid(t *testing.T) {
+    tests := []struct {
+        rules string
+        want  []string
+    }{
+        {"rules:\n  - key: a\n    algorithm: gcra\n    limit: 10\n    interval: 1m\n    bursts: 2\n", []string{`line 6: unknown field "bursts"`}},
+        {"rules:\n  - key: a\n    algorithm: gcra\n    limit: -1\n    interval: 1m\n", []string{"line 4: limit must be positive"}},
+        {"rules:\n  - key: a\n    algorithm: gcra\n    limit: 10\n    interval: 0s\n", []string{"line 5: interval must be positive"}},
+        {"rules:\n  - key: a\n    algorithm: gcra\n    limit: ten\n    interval: soon\n", []string{
+            `line 4: limit must be an integer, got "ten"`,
+            `line 5: interval must be a duration such as 1m, got "soon"`,
+        }},
+        {"rules:\n  - key: a\n    algorithm: gcra\n", []string{`line 2: missing field "limit"`, `line 2: missing field "interval"`}},
+        {"rules:\n  - key: \"[\"\n    algorithm: gcra\n    limit: 10\n    interval: 1m\n", []string{`line 2: invalid key pattern "["`}},
+        {"rules:\n  - key: a\n    algorithm: gcar\n    limit: 10\n    interval: 1m\n", []string{`line 3: unknown algorithm "gcar"`}},
+        {"limits:\n  - key: a\n", []string{`line 1: unknown field "limits"`}},
+        {"", []string{"line 1: no rules"}},
+    }
+    for _, tt := range tests {
+        _, err := ParseRules([]byte(tt.rules))
+        var errs RuleErrors
+        if !errors.As(err, &errs) {
+            t.Errorf("%q: expected RuleErrors, got %v", tt.rules, err)
+            continue
+        }
+        for _, want := range tt.want {
+            if !strings.Contains(err.Error(), want) {
+                t.Errorf("%q: expected error to contain %q, got %q", tt.rules, want, err)
+            }
+        }
+    }
+}
+
+func TestRules_Resolve(t *testing.T) {
+    rules, err := ParseRules([]byte(testRules))
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    ctx := context.Background()
+
+    limits, err := LimitsFor(ctx, rules, "search:alice")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if limits.Requests != 100 {
+        t.Errorf("expected the first matching rule to apply, got %+v", limits)
+    }
+
+    limits, _ = LimitsFor(ctx, rules, "checkout")
+    if limits.Requests != 10 {
+        t.Errorf("expected the catch-all rule to apply, got %+v", limits)
+    }
+
+    rules, _ = ParseRules([]byte("rules:\n  - key: \"a:*\"\n    algorithm: gcra\n    limit: 10\n    interval: 1m\n"))
+    if _, err := rules.Resolve(ctx, "b", nil); !errors.Is(err, ErrNoLimits) {
+        t.Errorf("expected ErrNoLimits for an unmatched key, got %v", err)
+    }
+}
+
+func TestLoadRules(t *testing.T) {
+    name := filepath.Join(t.TempDir(), "rules.yaml")
+    if err := os.WriteFile(name, []byte(testRules), 0o600); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    rules, err := LoadRules(name)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if len(rules.Rules()) != 2 {
+        t.Errorf("expected 2 rules, got %d", len(rules.Rules()))
+    }
+
+    if _, err := LoadRules(filepath.Join(t.TempDir(), "missing.yaml")); !errors.Is(err, os.ErrNotExist) {
+        t.Errorf("expected a missing file to fail, got %v", err)
+    }
+}
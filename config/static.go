@@ -6,7 +6,9 @@ import (
     "time"
 )
 
-// Static is a static implementation of the Config interface.
+// Static is a static implementation of the Config interface. Its limits never change, so it can be passed wherever
+// a Policy is expected; its token state is kept only for callers of the deprecated Config methods and is not used
+// by any limiter. New code should use Limits, and Static.Limits converts existing values.
 type Static struct {
     maxRequests int
     interval    time.Duration
@@ -27,6 +29,11 @@ func NewStatic(maxRequests int, interval time.Duration, burstLimit int, tokens i
     }
 }
 
+// Limits returns the static config's limits as an immutable Policy.
+func (c *Static) Limits() Limits {
+    return Limits{Requests: c.maxRequests, Period: c.interval, Burst: c.burstLimit}
+}
+
 // MaxRequests returns the max requests from the static config.
 func (c *Static) MaxRequests(ctx context.Context) (int, error) {
     return c.maxRequests, nil
@@ -43,6 +50,8 @@ func (c *Static) BurstLimit(ctx context.Context) (int, error) {
 }
 
 // Tokens returns the current token count from the static config.
+//
+// Deprecated: limiters keep their state in storage.
 func (c *Static) Tokens(ctx context.Context) (int, error) {
     c.mu.Lock()
     defer c.mu.Unlock()
@@ -50,6 +59,8 @@ func (c *Static) Tokens(ctx context.Context) (int, error) {
 }
 
 // SetTokens sets the current token count in the static config.
+//
+// Deprecated: limiters keep their state in storage.
 func (c *Static) SetTokens(ctx context.Context, tokens int) error {
     c.mu.Lock()
     defer c.mu.Unlock()
@@ -58,6 +69,8 @@ func (c *Static) SetTokens(ctx context.Context, tokens int) error {
 }
 
 // LastRefill returns the last refill time from the static config.
+//
+// Deprecated: limiters keep their state in storage.
 func (c *Static) LastRefill(ctx context.Context) (time.Time, error) {
     c.mu.Lock()
     defer c.mu.Unlock()
@@ -65,6 +78,8 @@ func (c *Static) LastRefill(ctx context.Context) (time.Time, error) {
 }
 
 // SetLastRefill sets the last refill time in the static config.
+//
+// Deprecated: limiters keep their state in storage.
 func (c *Static) SetLastRefill(ctx context.Context, lastRefill time.Time) error {
     c.mu.Lock()
     defer c.mu.Unlock()
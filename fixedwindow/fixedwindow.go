@@ -2,86 +2,268 @@ package fixedwindow
 
 import (
     "context"
+    "math"
     "sync"
     "time"
 
     "github.com/umbeluzi/ratelimit/config"
+    "github.com/umbeluzi/ratelimit/limiter"
     "github.com/umbeluzi/ratelimit/storage"
 )
 
+// Name is the name of the fixed window algorithm.
+const Name = "fixedwindow"
+
 // FixedWindow is an implementation of the fixed window rate limiting algorithm.
 type FixedWindow struct {
     storage storage.Storage
-    config  config.Config
+    policy  config.Policy
     mu      sync.Mutex
 }
 
 // New creates a new FixedWindow rate limiter.
-func New(storage storage.Storage, config config.Config) *FixedWindow {
+func New(storage storage.Storage, policy config.Policy) *FixedWindow {
     return &FixedWindow{
         storage: storage,
-        config:  config,
+        policy:  policy,
     }
 }
 
 // Allow checks if a request is allowed for a given key using the fixed window algorithm.
 func (fw *FixedWindow) Allow(ctx context.Context, key string) (bool, error) {
+    return fw.AllowN(ctx, key, 1)
+}
+
+// AllowN checks if a request costing n units is allowed for a given key using the fixed window algorithm.
+// If the request would exceed the remaining quota, it is denied and nothing is consumed.
+func (fw *FixedWindow) AllowN(ctx context.Context, key string, n int) (bool, error) {
+    result, err := fw.AllowResult(ctx, key, n)
+    if err != nil {
+        return false, err
+    }
+    return result.Allowed, nil
+}
+
+// Wait blocks until a request is allowed for a given key using the fixed window algorithm, or ctx is done.
+func (fw *FixedWindow) Wait(ctx context.Context, key string) error {
+    return fw.WaitN(ctx, key, 1)
+}
+
+// WaitN blocks until a request costing n units is allowed for a given key using the fixed window algorithm, or ctx is
+// done. It fails immediately if n exceeds the limit or the request would not be allowed before ctx's deadline.
+func (fw *FixedWindow) WaitN(ctx context.Context, key string, n int) error {
+    return limiter.Wait(ctx, n, func(ctx context.Context) (limiter.Result, error) {
+        return fw.AllowResult(ctx, key, n)
+    })
+}
+
+// AllowResult is like AllowN but reports the full outcome of the decision, evaluated in a single storage operation.
+// A non-positive n consumes nothing and only reports the current quota.
+func (fw *FixedWindow) AllowResult(ctx context.Context, key string, n int) (limiter.Result, error) {
+    if n < 0 {
+        n = 0
+    }
+
     fw.mu.Lock()
     defer fw.mu.Unlock()
 
-    maxRequests, err := fw.config.MaxRequests(ctx)
+    limits, err := config.LimitsFor(ctx, fw.policy, key)
     if err != nil {
-        return false, err
+        return limiter.Result{}, err
     }
+    maxRequests, window, burstLimit := limits.Requests, limits.Period, limits.Burst
 
-    window, err := fw.config.Interval(ctx)
+    granted, err := limiter.Granted(ctx, fw.storage, key)
     if err != nil {
-        return false, err
+        return limiter.Result{}, err
     }
 
-    burstLimit, err := fw.config.BurstLimit(ctx)
+    limit := maxRequests + burstLimit + granted
+    count, ttl, allowed, err := storage.IncrementWithLimit(ctx, fw.storage, key, n, limit, window)
     if err != nil {
-        return false, err
+        return limiter.Result{}, err
+    }
+
+    remaining := limit - count
+    if remaining < 0 {
+        remaining = 0
     }
 
-    count, err := fw.storage.Increment(ctx, key)
+    result := limiter.Result{
+        Allowed:   allowed,
+        Limit:     limit,
+        Remaining: remaining,
+        ResetAt:   time.Now().Add(ttl),
+    }
+    if !allowed {
+        result.RetryAfter = ttl
+    }
+
+    return result, nil
+}
+
+// Check reports the result AllowResult would return for a request costing n units, without consuming anything.
+// It only reads the key's counter and TTL.
+func (fw *FixedWindow) Check(ctx context.Context, key string, n int) (limiter.Result, error) {
+    if n < 0 {
+        n = 0
+    }
+
+    limits, err := config.LimitsFor(ctx, fw.policy, key)
     if err != nil {
-        return false, err
+        return limiter.Result{}, err
     }
+    maxRequests, window, burstLimit := limits.Requests, limits.Period, limits.Burst
 
-    if count == 1 {
-        // Set a TTL if this is the first request
-        err := fw.storage.SetTTL(ctx, key, window)
-        if err != nil {
-            return false, err
+    count, err := fw.storage.Get(ctx, key)
+    if err != nil {
+        return limiter.Result{}, err
+    }
+
+    ttl, err := fw.storage.TTL(ctx, key)
+    if err != nil {
+        return limiter.Result{}, err
+    }
+
+    granted, err := limiter.Granted(ctx, fw.storage, key)
+    if err != nil {
+        return limiter.Result{}, err
+    }
+
+    limit := maxRequests + burstLimit + granted
+    allowed := count+n <= limit
+    if allowed {
+        count += n
+        // An admitted request opens a new window if there is none
+        if ttl <= 0 {
+            ttl = window
         }
     }
 
-    if count > maxRequests+burstLimit {
-        return false, nil
+    remaining := limit - count
+    if remaining < 0 {
+        remaining = 0
+    }
+
+    result := limiter.Result{
+        Allowed:   allowed,
+        Limit:     limit,
+        Remaining: remaining,
+        ResetAt:   time.Now().Add(ttl),
+    }
+    if !allowed {
+        result.RetryAfter = ttl
+    }
+
+    return result, nil
+}
+
+// Reserve reserves n units for a given key. A fixed window can only reserve units available in the current
+// window, so the reservation is OK if the request is allowed now and never has a delay; otherwise its Delay
+// reports when the window resets. Canceling it returns the units to the window.
+func (fw *FixedWindow) Reserve(ctx context.Context, key string, n int) (*limiter.Reservation, error) {
+    result, err := fw.AllowResult(ctx, key, n)
+    if err != nil {
+        return nil, err
+    }
+
+    now := time.Now()
+    if !result.Allowed {
+        return limiter.NewReservation(false, now.Add(result.RetryAfter), nil), nil
     }
+    return limiter.NewReservation(true, now, func(ctx context.Context) error {
+        return fw.Refund(ctx, key, n)
+    }), nil
+}
 
-    return true, nil
+// Refund credits back n units previously consumed for a given key, for requests that should not count against
+// the limit after all. Units are only credited to the current window, and never below zero.
+func (fw *FixedWindow) Refund(ctx context.Context, key string, n int) error {
+    if n <= 0 {
+        return nil
+    }
+    _, err := fw.storage.DecrementBy(ctx, key, n)
+    return err
+}
+
+// Charge consumes n units for a given key after the fact, even if that exceeds the limit, for costs that are
+// only known once a request has been served. Later requests are denied until the window resets.
+func (fw *FixedWindow) Charge(ctx context.Context, key string, n int) error {
+    if n <= 0 {
+        return nil
+    }
+
+    limits, err := config.LimitsFor(ctx, fw.policy, key)
+    if err != nil {
+        return err
+    }
+    window := limits.Period
+
+    _, _, _, err = storage.IncrementWithLimit(ctx, fw.storage, key, n, math.MaxInt, window)
+    return err
+}
+
+// Reset clears the usage recorded for a given key, giving it its full quota back. Quota granted to the key is kept.
+func (fw *FixedWindow) Reset(ctx context.Context, key string) error {
+    return fw.storage.Reset(ctx, key)
+}
+
+// Grant raises the limit of a given key by n units for the given duration, on top of the configured limit and burst.
+// Granting again adds to the current grant and restarts its expiry. See limiter.Grant.
+func (fw *FixedWindow) Grant(ctx context.Context, key string, n int, ttl time.Duration) error {
+    return limiter.Grant(ctx, fw.storage, key, n, ttl)
+}
+
+// Revoke withdraws any units granted to a given key before the grant expires.
+func (fw *FixedWindow) Revoke(ctx context.Context, key string) error {
+    return limiter.Revoke(ctx, fw.storage, key)
 }
 
 // Quota returns the current quota information.
-func (fw *FixedWindow) Quota(ctx context.Context, key string) (int, int, int, error) {
+func (fw *FixedWindow) Quota(ctx context.Context, key string) (limiter.Quota, error) {
     count, err := fw.storage.Get(ctx, key)
     if err != nil {
-        return 0, 0, 0, err
+        return limiter.Quota{}, err
     }
 
-    maxRequests, err := fw.config.MaxRequests(ctx)
+    ttl, err := fw.storage.TTL(ctx, key)
+    if err != nil {
+        return limiter.Quota{}, err
+    }
+
+    limits, err := config.LimitsFor(ctx, fw.policy, key)
     if err != nil {
-        return 0, 0, 0, err
+        return limiter.Quota{}, err
     }
+    maxRequests, window, burstLimit := limits.Requests, limits.Period, limits.Burst
 
-    burstLimit, err := fw.config.BurstLimit(ctx)
+    granted, err := limiter.Granted(ctx, fw.storage, key)
     if err != nil {
-        return 0, 0, 0, err
+        return limiter.Quota{}, err
+    }
+
+    remaining := maxRequests + burstLimit + granted - count
+    if remaining < 0 {
+        remaining = 0
+    }
+
+    // Without a live window, report the one the next request would open
+    now := time.Now()
+    end := now.Add(window)
+    if ttl > 0 {
+        end = now.Add(ttl)
     }
 
-    return count, maxRequests, burstLimit, nil
+    return limiter.Quota{
+        Algorithm:   Name,
+        Used:        count,
+        Limit:       maxRequests,
+        Burst:       burstLimit,
+        Granted:     granted,
+        Remaining:   remaining,
+        WindowStart: end.Add(-window),
+        WindowEnd:   end,
+    }, nil
 }
 
 // NextAllowed returns the time duration until the next allowed request.
@@ -6,20 +6,47 @@ import (
     "time"
 
     "github.com/umbeluzi/ratelimit/config"
+    "github.com/umbeluzi/ratelimit/limiter"
     "github.com/umbeluzi/ratelimit/storage"
 )
 
+var _ storage.Storage = (*MockStorage)(nil)
+
+// MockStorage counts requests for a single key, keeping any quota granted to it apart.
 type MockStorage struct {
-    count int
+    count   int
+    granted int
+}
+
+// counter returns the counter a key refers to.
+func (ms *MockStorage) counter(key string) *int {
+    if key == limiter.GrantKey("test") {
+        return &ms.granted
+    }
+    return &ms.count
 }
 
 func (ms *MockStorage) Increment(ctx context.Context, key string) (int, error) {
-    ms.count++
-    return ms.count, nil
+    return ms.IncrementBy(ctx, key, 1)
+}
+
+func (ms *MockStorage) IncrementBy(ctx context.Context, key string, n int) (int, error) {
+    c := ms.counter(key)
+    *c += n
+    return *c, nil
+}
+
+func (ms *MockStorage) DecrementBy(ctx context.Context, key string, n int) (int, error) {
+    c := ms.counter(key)
+    *c -= n
+    if *c < 0 {
+        *c = 0
+    }
+    return *c, nil
 }
 
 func (ms *MockStorage) Reset(ctx context.Context, key string) error {
-    ms.count = 0
+    *ms.counter(key) = 0
     return nil
 }
 
@@ -32,7 +59,7 @@ func (ms *MockStorage) SetTTL(ctx context.Context, key string, ttl time.Duration
 }
 
 func (ms *MockStorage) Get(ctx context.Context, key string) (int, error) {
-    return ms.count, nil
+    return *ms.counter(key), nil
 }
 
 func TestFixedWindow_Allow(t *testing.T) {
@@ -41,18 +68,103 @@ func TestFixedWindow_Allow(t *testing.T) {
 
     fw := New(storage, config)
 
-    for i := 0; i < 7; i++ {
+    for i := 0; i < 9; i++ {
         allowed, err := fw.Allow(context.Background(), "test")
         if err != nil {
             t.Errorf("unexpected error: %v", err)
         }
 
-        if i < 5 && !allowed {
+        if i < 7 && !allowed {
             t.Errorf("request %d should be allowed", i+1)
         }
 
-        if i >= 5 && allowed {
+        if i >= 7 && allowed {
             t.Errorf("request %d should be denied", i+1)
         }
     }
 }
+
+func TestFixedWindow_AllowN(t *testing.T) {
+    storage := &MockStorage{}
+    config := config.NewStatic(5, time.Minute, 2, 0, time.Now())
+
+    fw := New(storage, config)
+
+    steps := []struct {
+        n       int
+        allowed bool
+    }{
+        {5, true},
+        {3, false},
+        {2, true},
+        {1, false},
+    }
+
+    for i, step := range steps {
+        allowed, err := fw.AllowN(context.Background(), "test", step.n)
+        if err != nil {
+            t.Errorf("unexpected error: %v", err)
+        }
+
+        if allowed != step.allowed {
+            t.Errorf("step %d: expected allowed=%v for n=%d, got %v", i+1, step.allowed, step.n, allowed)
+        }
+    }
+
+    if storage.count != 7 {
+        t.Errorf("expected denied requests not to consume quota, got count %d", storage.count)
+    }
+}
+
+func TestFixedWindow_AllowResult(t *testing.T) {
+    storage := &MockStorage{}
+    config := config.NewStatic(5, time.Minute, 2, 0, time.Now())
+
+    fw := New(storage, config)
+
+    result, err := fw.AllowResult(context.Background(), "test", 5)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if !result.Allowed || result.Limit != 7 || result.Remaining != 2 || result.RetryAfter != 0 {
+        t.Errorf("unexpected result for allowed request: %+v", result)
+    }
+    if until := time.Until(result.ResetAt); until <= 0 || until > time.Minute {
+        t.Errorf("expected ResetAt within the next minute, got %s", until)
+    }
+
+    result, err = fw.AllowResult(context.Background(), "test", 3)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if result.Allowed || result.Remaining != 2 {
+        t.Errorf("unexpected result for denied request: %+v", result)
+    }
+    if result.RetryAfter != time.Minute {
+        t.Errorf("expected RetryAfter of 1m, got %s", result.RetryAfter)
+    }
+}
+
+func TestFixedWindow_Quota(t *testing.T) {
+    storage := &MockStorage{}
+    config := config.NewStatic(5, time.Minute, 2, 0, time.Now())
+
+    fw := New(storage, config)
+
+    for i := 0; i < 3; i++ {
+        fw.Allow(context.Background(), "test")
+    }
+
+    quota, err := fw.Quota(context.Background(), "test")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    if quota.Algorithm != Name || quota.Used != 3 || quota.Limit != 5 || quota.Burst != 2 || quota.Remaining != 4 {
+        t.Errorf("unexpected quota: %+v", quota)
+    }
+
+    if quota.WindowEnd.Sub(quota.WindowStart) != time.Minute {
+        t.Errorf("expected a one minute window, got %s to %s", quota.WindowStart, quota.WindowEnd)
+    }
+}
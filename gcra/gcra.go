@@ -0,0 +1,430 @@
+package gcra
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/umbeluzi/ratelimit/config"
+    "github.com/umbeluzi/ratelimit/limiter"
+    "github.com/umbeluzi/ratelimit/storage"
+)
+
+// Name is the name of the generic cell rate algorithm.
+const Name = "gcra"
+
+// ErrInvalidRate is returned when the configured number of requests or interval is not positive, or when the
+// interval is shorter than one nanosecond per request, which leaves no emission interval to space requests by.
+var ErrInvalidRate = errors.New("gcra: max requests and interval must be positive, with at least 1ns per request")
+
+// GCRA is an implementation of the generic cell rate algorithm.
+// It stores a single theoretical arrival time (TAT) per key: the time at which the key's quota would be fully
+// replenished. Each request pushes the TAT forward by one emission interval, Interval / MaxRequests, and is allowed
+// if the TAT stays within MaxRequests + BurstLimit emission intervals of now. This gives exact token bucket
+// semantics with one storage value and no background goroutine.
+type GCRA struct {
+    storage storage.StateStorage
+    policy  config.Policy
+    now     func() time.Time
+}
+
+// New creates a new GCRA rate limiter.
+func New(storage storage.StateStorage, policy config.Policy) *GCRA {
+    return &GCRA{
+        storage: storage,
+        policy:  policy,
+        now:     time.Now,
+    }
+}
+
+// rate holds the parameters derived from the configuration.
+type rate struct {
+    maxRequests int
+    burstLimit  int
+    interval    time.Duration
+    // granted is the number of units temporarily granted to the key.
+    granted int
+    // emission is the time one request adds to the TAT.
+    emission time.Duration
+    // tolerance is how far ahead of now the TAT may be, the time it takes to replenish the whole limit.
+    tolerance time.Duration
+}
+
+// limit returns the number of requests allowed at once.
+func (r rate) limit() int {
+    return r.maxRequests + r.burstLimit + r.granted
+}
+
+// remaining returns the number of requests allowed at now given the TAT.
+func (r rate) remaining(tat, now time.Time) int {
+    remaining := int((r.tolerance - tat.Sub(now)) / r.emission)
+    if remaining < 0 {
+        return 0
+    }
+    return remaining
+}
+
+// decide evaluates a request costing n units against the TAT, which must not lie before now. It returns the
+// result and, if the request is allowed, the new TAT.
+func (r rate) decide(tat, now time.Time, n int) (limiter.Result, time.Time) {
+    next := tat.Add(r.emission * time.Duration(n))
+    allowAt := next.Add(-r.tolerance)
+    if now.Before(allowAt) {
+        result := limiter.Result{
+            Allowed:    false,
+            Limit:      r.limit(),
+            Remaining:  r.remaining(tat, now),
+            ResetAt:    tat,
+            RetryAfter: allowAt.Sub(now),
+        }
+        if n > r.limit() {
+            // The request does not fit even into a fully replenished quota
+            result.RetryAfter = r.interval
+        }
+        return result, time.Time{}
+    }
+
+    return limiter.Result{
+        Allowed:   true,
+        Limit:     r.limit(),
+        Remaining: r.remaining(next, now),
+        ResetAt:   next,
+    }, next
+}
+
+// rate reads the configuration and the units granted to key.
+func (g *GCRA) rate(ctx context.Context, key string) (rate, error) {
+    limits, err := config.LimitsFor(ctx, g.policy, key)
+    if err != nil {
+        return rate{}, err
+    }
+    maxRequests, interval, burstLimit := limits.Requests, limits.Period, limits.Burst
+
+    if maxRequests <= 0 || interval <= 0 {
+        return rate{}, ErrInvalidRate
+    }
+
+    granted, err := limiter.Granted(ctx, g.storage, key)
+    if err != nil {
+        return rate{}, err
+    }
+
+    emission := interval / time.Duration(maxRequests)
+    if emission == 0 {
+        return rate{}, ErrInvalidRate
+    }
+
+    return rate{
+        maxRequests: maxRequests,
+        burstLimit:  burstLimit,
+        interval:    interval,
+        granted:     granted,
+        emission:    emission,
+        tolerance:   emission * time.Duration(maxRequests+burstLimit+granted),
+    }, nil
+}
+
+// decodeTAT parses a TAT stored as "<Unix nanoseconds>", or as "<Unix nanoseconds>:<granted units>" if units
+// were granted to the key when it was stored. A missing state yields the zero time. If the grant has since expired
+// or been revoked, the TAT is clamped to now plus the tolerance, so units used while the grant lasted are not paid
+// back at the configured rate once it ends.
+func (r rate) decodeTAT(state []byte, now time.Time) (time.Time, error) {
+    if state == nil {
+        return time.Time{}, nil
+    }
+    nanos, granted, ok := strings.Cut(string(state), ":")
+    unixNanos, err := strconv.ParseInt(nanos, 10, 64)
+    if err != nil {
+        return time.Time{}, fmt.Errorf("gcra: malformed state %q: %w", state, err)
+    }
+    tat := time.Unix(0, unixNanos)
+    if !ok {
+        return tat, nil
+    }
+
+    wasGranted, err := strconv.Atoi(granted)
+    if err != nil {
+        return time.Time{}, fmt.Errorf("gcra: malformed state %q: %w", state, err)
+    }
+    if limit := now.Add(r.tolerance); wasGranted > r.granted && tat.After(limit) {
+        tat = limit
+    }
+    return tat, nil
+}
+
+// encodeTAT formats a TAT for decodeTAT.
+func (r rate) encodeTAT(tat time.Time) []byte {
+    state := strconv.FormatInt(tat.UnixNano(), 10)
+    if r.granted > 0 {
+        state += ":" + strconv.Itoa(r.granted)
+    }
+    return []byte(state)
+}
+
+// load returns the TAT stored for key, or now if it lies in the past.
+func (g *GCRA) load(ctx context.Context, key string, r rate, now time.Time) (time.Time, error) {
+    state, err := g.storage.GetState(ctx, key)
+    if err != nil {
+        return time.Time{}, err
+    }
+    tat, err := r.decodeTAT(state, now)
+    if err != nil {
+        return time.Time{}, err
+    }
+    if tat.Before(now) {
+        return now, nil
+    }
+    return tat, nil
+}
+
+// Allow checks if a request is allowed for a given key using the generic cell rate algorithm.
+func (g *GCRA) Allow(ctx context.Context, key string) (bool, error) {
+    return g.AllowN(ctx, key, 1)
+}
+
+// AllowN checks if a request costing n units is allowed for a given key using the generic cell rate algorithm.
+// If the request would exceed the remaining quota, it is denied and nothing is consumed.
+func (g *GCRA) AllowN(ctx context.Context, key string, n int) (bool, error) {
+    result, err := g.AllowResult(ctx, key, n)
+    if err != nil {
+        return false, err
+    }
+    return result.Allowed, nil
+}
+
+// Wait blocks until a request is allowed for a given key using the generic cell rate algorithm, or ctx is done.
+func (g *GCRA) Wait(ctx context.Context, key string) error {
+    return g.WaitN(ctx, key, 1)
+}
+
+// WaitN blocks until a request costing n units is allowed for a given key using the generic cell rate algorithm, or ctx is
+// done. It fails immediately if n exceeds the limit or the request would not be allowed before ctx's deadline.
+func (g *GCRA) WaitN(ctx context.Context, key string, n int) error {
+    return limiter.Wait(ctx, n, func(ctx context.Context) (limiter.Result, error) {
+        return g.AllowResult(ctx, key, n)
+    })
+}
+
+// AllowResult is like AllowN but reports the full outcome of the decision. The TAT is updated with a
+// compare-and-swap, so concurrent callers sharing a storage never admit more than the limit.
+// RetryAfter is the exact time until the request would be allowed.
+// A non-positive n consumes nothing and only reports the current quota.
+func (g *GCRA) AllowResult(ctx context.Context, key string, n int) (limiter.Result, error) {
+    if n < 0 {
+        n = 0
+    }
+
+    r, err := g.rate(ctx, key)
+    if err != nil {
+        return limiter.Result{}, err
+    }
+
+    var result limiter.Result
+    err = storage.UpdateState(ctx, g.storage, key, func(state []byte) ([]byte, time.Duration, error) {
+        now := g.now()
+        tat, err := r.decodeTAT(state, now)
+        if err != nil {
+            return nil, 0, err
+        }
+        if tat.Before(now) {
+            tat = now
+        }
+
+        var next time.Time
+        result, next = r.decide(tat, now, n)
+        if !result.Allowed || n == 0 {
+            return nil, 0, nil
+        }
+        return r.encodeTAT(next), next.Sub(now), nil
+    })
+    if err != nil {
+        return limiter.Result{}, err
+    }
+
+    return result, nil
+}
+
+// Check reports the result AllowResult would return for a request costing n units, without consuming anything.
+func (g *GCRA) Check(ctx context.Context, key string, n int) (limiter.Result, error) {
+    if n < 0 {
+        n = 0
+    }
+
+    r, err := g.rate(ctx, key)
+    if err != nil {
+        return limiter.Result{}, err
+    }
+
+    now := g.now()
+    tat, err := g.load(ctx, key, r, now)
+    if err != nil {
+        return limiter.Result{}, err
+    }
+
+    result, _ := r.decide(tat, now, n)
+    return result, nil
+}
+
+// Reserve reserves n units for a given key. Unlike AllowN, it also reserves units that only become available in
+// the future: the TAT is moved forward regardless, and the reservation's Delay reports when the units may be used.
+// The reservation is not OK only if n exceeds the limit. Canceling it moves the TAT back.
+func (g *GCRA) Reserve(ctx context.Context, key string, n int) (*limiter.Reservation, error) {
+    if n < 0 {
+        n = 0
+    }
+
+    r, err := g.rate(ctx, key)
+    if err != nil {
+        return nil, err
+    }
+    if n > r.limit() {
+        return limiter.NewReservation(false, g.now().Add(r.interval), nil), nil
+    }
+
+    at, err := g.reserve(ctx, key, r, n)
+    if err != nil {
+        return nil, err
+    }
+
+    return limiter.NewReservation(true, at, func(ctx context.Context) error {
+        return g.release(ctx, key, r, n)
+    }), nil
+}
+
+// Refund credits back n units previously consumed for a given key, for requests that should not count against
+// the limit after all. The TAT is moved back, but never before now.
+func (g *GCRA) Refund(ctx context.Context, key string, n int) error {
+    if n <= 0 {
+        return nil
+    }
+
+    r, err := g.rate(ctx, key)
+    if err != nil {
+        return err
+    }
+    return g.release(ctx, key, r, n)
+}
+
+// Charge consumes n units for a given key after the fact, even if that exceeds the limit, for costs that are
+// only known once a request has been served. The TAT is moved forward, delaying later requests accordingly.
+func (g *GCRA) Charge(ctx context.Context, key string, n int) error {
+    if n <= 0 {
+        return nil
+    }
+
+    r, err := g.rate(ctx, key)
+    if err != nil {
+        return err
+    }
+    _, err = g.reserve(ctx, key, r, n)
+    return err
+}
+
+// reserve moves the TAT stored for key forward by n emission intervals regardless of the limit, and returns the
+// time at which the n units may be used.
+func (g *GCRA) reserve(ctx context.Context, key string, r rate, n int) (time.Time, error) {
+    var at time.Time
+    err := storage.UpdateState(ctx, g.storage, key, func(state []byte) ([]byte, time.Duration, error) {
+        now := g.now()
+        tat, err := r.decodeTAT(state, now)
+        if err != nil {
+            return nil, 0, err
+        }
+        if tat.Before(now) {
+            tat = now
+        }
+
+        next := tat.Add(r.emission * time.Duration(n))
+        at = next.Add(-r.tolerance)
+        if n == 0 {
+            return nil, 0, nil
+        }
+        return r.encodeTAT(next), next.Sub(now), nil
+    })
+    return at, err
+}
+
+// release moves the TAT stored for key back by n emission intervals, returning n units.
+func (g *GCRA) release(ctx context.Context, key string, r rate, n int) error {
+    return storage.UpdateState(ctx, g.storage, key, func(state []byte) ([]byte, time.Duration, error) {
+        now := g.now()
+        tat, err := r.decodeTAT(state, now)
+        if err != nil || !tat.After(now) {
+            return nil, 0, err
+        }
+
+        tat = tat.Add(-r.emission * time.Duration(n))
+        ttl := tat.Sub(now)
+        if ttl <= 0 {
+            // A TAT in the past is the same as none; let it expire right away
+            tat, ttl = now, time.Nanosecond
+        }
+        return r.encodeTAT(tat), ttl, nil
+    })
+}
+
+// Reset clears the usage recorded for a given key, giving it its full quota back. Quota granted to the key is kept.
+func (g *GCRA) Reset(ctx context.Context, key string) error {
+    return g.storage.Reset(ctx, key)
+}
+
+// Grant raises the limit of a given key by n units for the given duration, on top of the configured limit and burst.
+// Granting again adds to the current grant and restarts its expiry. See limiter.Grant.
+func (g *GCRA) Grant(ctx context.Context, key string, n int, ttl time.Duration) error {
+    return limiter.Grant(ctx, g.storage, key, n, ttl)
+}
+
+// Revoke withdraws any units granted to a given key before the grant expires.
+func (g *GCRA) Revoke(ctx context.Context, key string) error {
+    return limiter.Revoke(ctx, g.storage, key)
+}
+
+// Quota returns the current quota information. The window runs from now until the TAT, when the key's quota is
+// fully replenished.
+func (g *GCRA) Quota(ctx context.Context, key string) (limiter.Quota, error) {
+    r, err := g.rate(ctx, key)
+    if err != nil {
+        return limiter.Quota{}, err
+    }
+
+    now := g.now()
+    tat, err := g.load(ctx, key, r, now)
+    if err != nil {
+        return limiter.Quota{}, err
+    }
+
+    remaining := r.remaining(tat, now)
+    return limiter.Quota{
+        Algorithm:   Name,
+        Used:        r.limit() - remaining,
+        Limit:       r.maxRequests,
+        Burst:       r.burstLimit,
+        Granted:     r.granted,
+        Remaining:   remaining,
+        WindowStart: now,
+        WindowEnd:   tat,
+    }, nil
+}
+
+// NextAllowed returns the exact time duration until the next allowed request.
+func (g *GCRA) NextAllowed(ctx context.Context, key string) (time.Duration, error) {
+    r, err := g.rate(ctx, key)
+    if err != nil {
+        return 0, err
+    }
+
+    now := g.now()
+    tat, err := g.load(ctx, key, r, now)
+    if err != nil {
+        return 0, err
+    }
+
+    allowAt := tat.Add(r.emission - r.tolerance)
+    if now.Before(allowAt) {
+        return allowAt.Sub(now), nil
+    }
+    return 0, nil
+}
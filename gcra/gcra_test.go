@@ -0,0 +1,201 @@
+package gcra
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/umbeluzi/ratelimit/config"
+    "github.com/umbeluzi/ratelimit/internal/clocktest"
+)
+
+func newTestLimiter(t *testing.T) *GCRA {
+    g := New(clocktest.Storage(t), clocktest.Policy())
+    clocktest.Pin(&g.now, 0)
+    return g
+}
+
+func TestGCRA_Allow(t *testing.T) {
+    g := newTestLimiter(t)
+
+    for i := 0; i < 9; i++ {
+        allowed, err := g.Allow(context.Background(), "test")
+        if err != nil {
+            t.Errorf("unexpected error: %v", err)
+        }
+
+        if i < 7 && !allowed {
+            t.Errorf("request %d should be allowed", i+1)
+        }
+
+        if i >= 7 && allowed {
+            t.Errorf("request %d should be denied", i+1)
+        }
+    }
+}
+
+func TestGCRA_AllowN(t *testing.T) {
+    g := newTestLimiter(t)
+
+    steps := []struct {
+        n       int
+        allowed bool
+    }{
+        {5, true},
+        {3, false},
+        {2, true},
+        {1, false},
+    }
+
+    for i, step := range steps {
+        allowed, err := g.AllowN(context.Background(), "test", step.n)
+        if err != nil {
+            t.Errorf("unexpected error: %v", err)
+        }
+
+        if allowed != step.allowed {
+            t.Errorf("step %d: expected allowed=%v for n=%d, got %v", i+1, step.allowed, step.n, allowed)
+        }
+    }
+
+    quota, _ := g.Quota(context.Background(), "test")
+    if quota.Used != 7 {
+        t.Errorf("expected denied requests not to consume quota, got %d used", quota.Used)
+    }
+}
+
+func TestGCRA_AllowResult(t *testing.T) {
+    g := newTestLimiter(t)
+    ctx := context.Background()
+
+    result, err := g.AllowResult(ctx, "test", 5)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if !result.Allowed || result.Limit != 7 || result.Remaining != 2 || result.RetryAfter != 0 {
+        t.Errorf("unexpected result for allowed request: %+v", result)
+    }
+    // Five requests take five emission intervals of 12s to replenish
+    if !result.ResetAt.Equal(clocktest.Start.Add(time.Minute)) {
+        t.Errorf("expected ResetAt after 1m, got %s", result.ResetAt)
+    }
+
+    result, err = g.AllowResult(ctx, "test", 3)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if result.Allowed || result.Remaining != 2 || result.RetryAfter != 12*time.Second {
+        t.Errorf("unexpected result for denied request: %+v", result)
+    }
+
+    // RetryAfter is exact: the request is denied just before it and allowed at it
+    clocktest.Pin(&g.now, 12*time.Second-time.Nanosecond)
+    if allowed, _ := g.AllowN(ctx, "test", 3); allowed {
+        t.Errorf("request should be denied before RetryAfter elapsed")
+    }
+    clocktest.Pin(&g.now, 12*time.Second)
+    if allowed, _ := g.AllowN(ctx, "test", 3); !allowed {
+        t.Errorf("request should be allowed once RetryAfter elapsed")
+    }
+
+    next, err := g.NextAllowed(ctx, "test")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if next != 12*time.Second {
+        t.Errorf("expected next request to be allowed in 12s, got %s", next)
+    }
+}
+
+func TestGCRA_Check(t *testing.T) {
+    g := newTestLimiter(t)
+    ctx := context.Background()
+
+    g.AllowN(ctx, "test", 7)
+
+    // A denied check reports the exact time until the TAT leaves room for the request
+    checked, err := g.Check(ctx, "test", 2)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if checked.Allowed || checked.RetryAfter != 24*time.Second || !checked.ResetAt.Equal(clocktest.Start.Add(84*time.Second)) {
+        t.Errorf("unexpected result for denied check: %+v", checked)
+    }
+
+    // An allowed check reports the TAT the request would move to, without moving it
+    clocktest.Pin(&g.now, 24*time.Second)
+    checked, _ = g.Check(ctx, "test", 2)
+    if !checked.Allowed || checked.Remaining != 0 || !checked.ResetAt.Equal(clocktest.Start.Add(108*time.Second)) {
+        t.Errorf("unexpected result for allowed check: %+v", checked)
+    }
+    if quota, _ := g.Quota(ctx, "test"); !quota.WindowEnd.Equal(clocktest.Start.Add(84 * time.Second)) {
+        t.Errorf("expected the TAT to stay at 84s, got %s", quota.WindowEnd)
+    }
+}
+
+func TestGCRA_Reserve(t *testing.T) {
+    g := newTestLimiter(t)
+    ctx := context.Background()
+
+    g.AllowN(ctx, "test", 7)
+
+    // Units beyond the limit are reserved in the future
+    reservation, err := g.Reserve(ctx, "test", 2)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if !reservation.OK() || reservation.DelayFrom(clocktest.Start) != 24*time.Second {
+        t.Errorf("expected a reservation in 24s, got OK=%v Delay=%s", reservation.OK(), reservation.DelayFrom(clocktest.Start))
+    }
+
+    next, _ := g.NextAllowed(ctx, "test")
+    if next != 36*time.Second {
+        t.Errorf("expected reserved units to delay the next request to 36s, got %s", next)
+    }
+
+    if err := reservation.Cancel(ctx); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    next, _ = g.NextAllowed(ctx, "test")
+    if next != 12*time.Second {
+        t.Errorf("expected canceling to bring the next request back to 12s, got %s", next)
+    }
+}
+
+func TestGCRA_Quota(t *testing.T) {
+    g := newTestLimiter(t)
+
+    for i := 0; i < 3; i++ {
+        g.Allow(context.Background(), "test")
+    }
+
+    quota, err := g.Quota(context.Background(), "test")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    if quota.Algorithm != Name || quota.Used != 3 || quota.Limit != 5 || quota.Burst != 2 || quota.Remaining != 4 {
+        t.Errorf("unexpected quota: %+v", quota)
+    }
+
+    if quota.WindowEnd.Sub(quota.WindowStart) != 36*time.Second {
+        t.Errorf("expected the quota to replenish in 36s, got %s to %s", quota.WindowStart, quota.WindowEnd)
+    }
+}
+
+func TestGCRA_InvalidRate(t *testing.T) {
+    storage := clocktest.Storage(t)
+    g := New(storage, config.NewStatic(0, time.Minute, 2, 0, time.Now()))
+    if _, err := g.Allow(context.Background(), "test"); err != ErrInvalidRate {
+        t.Errorf("expected ErrInvalidRate, got %v", err)
+    }
+
+    // Valid limits, but more requests than nanoseconds in the interval
+    g = New(storage, config.Limits{Requests: 2000, Period: time.Microsecond})
+    if _, err := g.Allow(context.Background(), "test"); err != ErrInvalidRate {
+        t.Errorf("expected ErrInvalidRate for a sub-nanosecond emission interval, got %v", err)
+    }
+    if _, err := g.Quota(context.Background(), "test"); err != ErrInvalidRate {
+        t.Errorf("expected ErrInvalidRate from Quota, got %v", err)
+    }
+}
@@ -3,6 +3,14 @@ module github.com/umbeluzi/ratelimit
 go 1.18
 
 require (
-    github.com/go-redis/redis/v9 v9.0.0
-    github.com/bradfitz/gomemcache/memcache latest
+	github.com/alicebob/miniredis/v2 v2.35.0
+	github.com/bradfitz/gomemcache v0.0.0-20230905024940-24af94b03874
+	github.com/redis/go-redis/v9 v9.7.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
 )
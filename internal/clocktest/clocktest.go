@@ -0,0 +1,34 @@
+// Package clocktest provides the fixture shared by the tests of limiters that read the time: a clock pinned
+// relative to a fixed start, and the storage and policy the tests run against.
+package clocktest
+
+import (
+    "testing"
+    "time"
+
+    "github.com/umbeluzi/ratelimit/config"
+    "github.com/umbeluzi/ratelimit/storage"
+)
+
+// Start is an arbitrary point in time; tests run against a clock pinned relative to it. It lies on a minute
+// boundary, so windows of a minute start at it.
+var Start = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// Pin replaces the clock now with one that always returns Start plus at.
+func Pin(now *func() time.Time, at time.Duration) {
+    *now = func() time.Time {
+        return Start.Add(at)
+    }
+}
+
+// Storage returns an in-memory storage without a background sweeper, closed when the test ends.
+func Storage(t testing.TB) *storage.InMemoryStorage {
+    s := storage.NewInMemoryStorageWithSweepInterval(0)
+    t.Cleanup(func() { s.Close() })
+    return s
+}
+
+// Policy returns the policy the tests run against: 5 requests per minute with a burst of 2.
+func Policy() config.Policy {
+    return config.Limits{Requests: 5, Period: time.Minute, Burst: 2}
+}
@@ -0,0 +1,45 @@
+// Package muldiv computes x*y/d for durations and counts whose product can exceed int64, such as a limit of a
+// million requests per day expressed in nanoseconds.
+package muldiv
+
+import (
+    "math"
+    "math/bits"
+)
+
+// Floor returns x*y/d rounded down, or math.MaxInt64 if the quotient does not fit. x and y must not be negative
+// and d must be positive.
+func Floor(x, y, d int64) int64 {
+    q, _, ok := divide(x, y, d)
+    if !ok {
+        return math.MaxInt64
+    }
+    return q
+}
+
+// Ceil returns x*y/d rounded up, or math.MaxInt64 if the quotient does not fit. x and y must not be negative and
+// d must be positive.
+func Ceil(x, y, d int64) int64 {
+    q, r, ok := divide(x, y, d)
+    if !ok || (r != 0 && q == math.MaxInt64) {
+        return math.MaxInt64
+    }
+    if r != 0 {
+        q++
+    }
+    return q
+}
+
+// divide returns the quotient and remainder of the 128-bit product x*y by d, reporting whether the quotient fits
+// into an int64.
+func divide(x, y, d int64) (q, r int64, ok bool) {
+    hi, lo := bits.Mul64(uint64(x), uint64(y))
+    if hi >= uint64(d) {
+        return 0, 0, false
+    }
+    uq, ur := bits.Div64(hi, lo, uint64(d))
+    if uq > math.MaxInt64 {
+        return 0, 0, false
+    }
+    return int64(uq), int64(ur), true
+}
@@ -0,0 +1,33 @@
+package muldiv
+
+import (
+    "math"
+    "testing"
+    "time"
+)
+
+func TestMulDiv(t *testing.T) {
+    day := int64(24 * time.Hour)
+    tests := []struct {
+        x, y, d     int64
+        floor, ceil int64
+    }{
+        {7, 3, 2, 10, 11},
+        {6, 3, 2, 9, 9},
+        {0, 5, 3, 0, 0},
+        {1000000, day, 1000000, day, day},
+        {day, 1000000, day, 1000000, 1000000},
+        {999999, day, 1000000, 86399913600000, 86399913600000},
+        {day - 1, 1000000, day, 999999, 1000000},
+        {math.MaxInt64, 2, 1, math.MaxInt64, math.MaxInt64},
+        {math.MaxInt64, math.MaxInt64, math.MaxInt64, math.MaxInt64, math.MaxInt64},
+    }
+    for _, tt := range tests {
+        if got := Floor(tt.x, tt.y, tt.d); got != tt.floor {
+            t.Errorf("Floor(%d, %d, %d): expected %d, got %d", tt.x, tt.y, tt.d, tt.floor, got)
+        }
+        if got := Ceil(tt.x, tt.y, tt.d); got != tt.ceil {
+            t.Errorf("Ceil(%d, %d, %d): expected %d, got %d", tt.x, tt.y, tt.d, tt.ceil, got)
+        }
+    }
+}
@@ -2,99 +2,518 @@ package leakybucket
 
 import (
     "context"
-    "sync"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
     "time"
 
     "github.com/umbeluzi/ratelimit/config"
+    "github.com/umbeluzi/ratelimit/internal/muldiv"
+    "github.com/umbeluzi/ratelimit/limiter"
     "github.com/umbeluzi/ratelimit/storage"
 )
 
+// Name is the name of the leaky bucket algorithm.
+const Name = "leakybucket"
+
+// ErrInvalidRate is returned when the configured number of requests or interval is not positive.
+var ErrInvalidRate = errors.New("leakybucket: max requests and interval must be positive")
+
+// Mode selects what a LeakyBucket does with requests while the bucket is not empty.
+type Mode int
+
+const (
+    // Meter admits requests immediately as long as they fit into the bucket, and rejects them otherwise.
+    Meter Mode = iota
+    // Queue delays requests until the units ahead of them have leaked out, so that requests leave the bucket
+    // at the leak rate. Requests that do not fit into the bucket are rejected.
+    Queue
+)
+
 // LeakyBucket is an implementation of the leaky bucket rate limiting algorithm.
+// Every key has a bucket holding up to MaxRequests + BurstLimit units, which leaks at MaxRequests units per
+// Interval. The bucket's level and last update time are kept in storage under the key, and the drained level is
+// computed from the elapsed time whenever the bucket is used, so memory per key is constant and no goroutines
+// are started.
 type LeakyBucket struct {
-    storage storage.Storage
-    config  config.Config
-    mu      sync.Mutex
+    storage storage.StateStorage
+    policy  config.Policy
+    mode    Mode
+    now     func() time.Time
+    sleep   func(ctx context.Context, d time.Duration) error
 }
 
-// New creates a new LeakyBucket rate limiter.
-func New(storage storage.Storage, config config.Config) *LeakyBucket {
+// New creates a new LeakyBucket rate limiter in Meter mode.
+func New(storage storage.StateStorage, policy config.Policy) *LeakyBucket {
+    return NewWithMode(storage, policy, Meter)
+}
+
+// NewWithMode creates a new LeakyBucket rate limiter in the given mode.
+func NewWithMode(storage storage.StateStorage, policy config.Policy, mode Mode) *LeakyBucket {
     return &LeakyBucket{
         storage: storage,
-        config:  config,
+        policy:  policy,
+        mode:    mode,
+        now:     time.Now,
+        sleep:   sleep,
     }
 }
 
-// Allow checks if a request is allowed for a given key using the leaky bucket algorithm.
-func (lb *LeakyBucket) Allow(ctx context.Context, key string) (bool, error) {
-    lb.mu.Lock()
-    defer lb.mu.Unlock()
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+    timer := time.NewTimer(d)
+    defer timer.Stop()
+    select {
+    case <-timer.C:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// rate holds the parameters derived from the configuration.
+type rate struct {
+    maxRequests int
+    burstLimit  int
+    interval    time.Duration
+    // granted is the number of units temporarily granted to the key.
+    granted int
+}
+
+// capacity returns the number of units the bucket holds.
+func (r rate) capacity() int {
+    return r.maxRequests + r.burstLimit + r.granted
+}
+
+// remaining returns the number of units that still fit into the bucket at the given level.
+func (r rate) remaining(level int) int {
+    if level >= r.capacity() {
+        return 0
+    }
+    return r.capacity() - level
+}
 
-    maxRequests, err := lb.config.MaxRequests(ctx)
+// drainTime returns how long it takes to leak n units, rounded up.
+func (r rate) drainTime(n int) time.Duration {
+    if n <= 0 {
+        return 0
+    }
+    return time.Duration(muldiv.Ceil(int64(n), int64(r.interval), int64(r.maxRequests)))
+}
+
+// rate reads the configuration and the units granted to key.
+func (lb *LeakyBucket) rate(ctx context.Context, key string) (rate, error) {
+    limits, err := config.LimitsFor(ctx, lb.policy, key)
     if err != nil {
-        return false, err
+        return rate{}, err
     }
+    maxRequests, interval, burstLimit := limits.Requests, limits.Period, limits.Burst
 
-    interval, err := lb.config.Interval(ctx)
+    if maxRequests <= 0 || interval <= 0 {
+        return rate{}, ErrInvalidRate
+    }
+
+    granted, err := limiter.Granted(ctx, lb.storage, key)
     if err != nil {
-        return false, err
+        return rate{}, err
     }
 
-    burstLimit, err := lb.config.BurstLimit(ctx)
+    return rate{maxRequests: maxRequests, burstLimit: burstLimit, interval: interval, granted: granted}, nil
+}
+
+// bucket is the state stored for each key.
+type bucket struct {
+    level      int
+    lastUpdate time.Time
+    // granted is the number of units granted to the key when the bucket was stored.
+    granted int
+}
+
+// decode parses a bucket stored as "<level>:<last update in Unix nanoseconds>", followed by ":<granted units>" if
+// units were granted to the key.
+func decode(state []byte) (bucket, error) {
+    fields := strings.Split(string(state), ":")
+    if len(fields) != 2 && len(fields) != 3 {
+        return bucket{}, fmt.Errorf("leakybucket: malformed state %q", state)
+    }
+    b := bucket{}
+    var err error
+    b.level, err = strconv.Atoi(fields[0])
     if err != nil {
-        return false, err
+        return bucket{}, fmt.Errorf("leakybucket: malformed state %q: %w", state, err)
+    }
+    nanos, err := strconv.ParseInt(fields[1], 10, 64)
+    if err != nil {
+        return bucket{}, fmt.Errorf("leakybucket: malformed state %q: %w", state, err)
+    }
+    b.lastUpdate = time.Unix(0, nanos)
+    if len(fields) == 3 {
+        if b.granted, err = strconv.Atoi(fields[2]); err != nil {
+            return bucket{}, fmt.Errorf("leakybucket: malformed state %q: %w", state, err)
+        }
+    }
+    return b, nil
+}
+
+// encode serializes the bucket for decode.
+func (b bucket) encode() []byte {
+    state := strconv.Itoa(b.level) + ":" + strconv.FormatInt(b.lastUpdate.UnixNano(), 10)
+    if b.granted > 0 {
+        state += ":" + strconv.Itoa(b.granted)
+    }
+    return []byte(state)
+}
+
+// drain returns the bucket as of now. A missing state is an empty bucket. Whole units are leaked for the time
+// elapsed since the last update, and the last update time only advances by the time those units account for,
+// so partial progress towards the next unit is kept. If the grant the bucket was filled under has since expired
+// or been revoked, the level is clamped to the capacity, so units used while the grant lasted are not paid back
+// at the configured rate once it ends.
+func (r rate) drain(state []byte, now time.Time) (bucket, error) {
+    if state == nil {
+        return bucket{lastUpdate: now, granted: r.granted}, nil
+    }
+    b, err := decode(state)
+    if err != nil {
+        return bucket{}, err
+    }
+
+    elapsed := now.Sub(b.lastUpdate)
+    switch {
+    case elapsed <= 0:
+    case elapsed >= r.drainTime(b.level):
+        b.level, b.lastUpdate = 0, now
+    default:
+        leaked := int(muldiv.Floor(int64(elapsed), int64(r.maxRequests), int64(r.interval)))
+        b.level -= leaked
+        b.lastUpdate = b.lastUpdate.Add(time.Duration(muldiv.Floor(int64(leaked), int64(r.interval), int64(r.maxRequests))))
+    }
+
+    if b.granted > r.granted && b.level > r.capacity() {
+        b.level = r.capacity()
+    }
+    b.granted = r.granted
+    return b, nil
+}
+
+// drainedAt returns the time at which the bucket's level has dropped to at most level.
+func (r rate) drainedAt(b bucket, level int) time.Time {
+    if b.level <= level {
+        return b.lastUpdate
+    }
+    return b.lastUpdate.Add(r.drainTime(b.level - level))
+}
+
+// decide evaluates a request costing n units against the bucket as of now. It returns the result and the bucket
+// with the units added if the request is allowed.
+func (r rate) decide(b bucket, now time.Time, n int) (limiter.Result, bucket) {
+    if b.level+n > r.capacity() {
+        result := limiter.Result{
+            Allowed:    false,
+            Limit:      r.capacity(),
+            Remaining:  r.remaining(b.level),
+            ResetAt:    r.drainedAt(b, 0),
+            RetryAfter: r.drainedAt(b, r.capacity()-n).Sub(now),
+        }
+        if n > r.capacity() {
+            // The request does not fit even into an empty bucket
+            result.RetryAfter = r.interval
+        }
+        return result, b
     }
 
-    count, err := lb.storage.Increment(ctx, key)
+    b.level += n
+    return limiter.Result{
+        Allowed:   true,
+        Limit:     r.capacity(),
+        Remaining: r.remaining(b.level),
+        ResetAt:   r.drainedAt(b, 0),
+    }, b
+}
+
+// Allow checks if a request is allowed for a given key using the leaky bucket algorithm.
+// In Queue mode it blocks until the request's turn.
+func (lb *LeakyBucket) Allow(ctx context.Context, key string) (bool, error) {
+    return lb.AllowN(ctx, key, 1)
+}
+
+// AllowN checks if a request costing n units is allowed for a given key using the leaky bucket algorithm.
+// If the request does not fit into the bucket, it is denied and nothing is consumed. In Queue mode it blocks
+// until the request's turn.
+func (lb *LeakyBucket) AllowN(ctx context.Context, key string, n int) (bool, error) {
+    result, err := lb.AllowResult(ctx, key, n)
     if err != nil {
         return false, err
     }
+    return result.Allowed, nil
+}
+
+// Wait blocks until a request is allowed for a given key using the leaky bucket algorithm, or ctx is done.
+func (lb *LeakyBucket) Wait(ctx context.Context, key string) error {
+    return lb.WaitN(ctx, key, 1)
+}
+
+// WaitN blocks until a request costing n units is allowed for a given key using the leaky bucket algorithm, or ctx is
+// done. It fails immediately if n exceeds the limit or the request would not be allowed before ctx's deadline.
+func (lb *LeakyBucket) WaitN(ctx context.Context, key string, n int) error {
+    return limiter.Wait(ctx, n, func(ctx context.Context) (limiter.Result, error) {
+        return lb.AllowResult(ctx, key, n)
+    })
+}
+
+// AllowResult is like AllowN but reports the full outcome of the decision. The bucket is updated with a
+// compare-and-swap, so concurrent callers sharing a storage never overfill it.
+// In Queue mode an admitted request waits until the units ahead of it have leaked out. If that wait would outlast
+// ctx's deadline, nothing is added to the bucket and limiter.ErrExceedsDeadline is returned. If ctx is done while
+// waiting, its units are taken out of the bucket again and the context's error is returned.
+// A non-positive n consumes nothing and only reports the current quota.
+func (lb *LeakyBucket) AllowResult(ctx context.Context, key string, n int) (limiter.Result, error) {
+    if n < 0 {
+        n = 0
+    }
 
-    if count == 1 {
-        // Set a TTL if this is the first request
-        err := lb.storage.SetTTL(ctx, key, interval)
+    r, err := lb.rate(ctx, key)
+    if err != nil {
+        return limiter.Result{}, err
+    }
+
+    var (
+        result limiter.Result
+        delay  time.Duration
+        late   bool
+    )
+    err = storage.UpdateState(ctx, lb.storage, key, func(state []byte) ([]byte, time.Duration, error) {
+        now := lb.now()
+        b, err := r.drain(state, now)
         if err != nil {
-            return false, err
+            return nil, 0, err
+        }
+
+        delay = 0
+        if lb.mode == Queue {
+            delay = r.drainedAt(b, 0).Sub(now)
         }
+
+        result, b = r.decide(b, now, n)
+        if !result.Allowed || n == 0 {
+            return nil, 0, nil
+        }
+        if deadline, ok := ctx.Deadline(); ok && delay > 0 && time.Until(deadline) < delay {
+            // The request's turn comes too late; do not queue it at all
+            late = true
+            return nil, 0, nil
+        }
+        // Once the bucket is empty, its state is the same as a missing one
+        return b.encode(), result.ResetAt.Sub(now), nil
+    })
+    if err != nil {
+        return limiter.Result{}, err
+    }
+    if late {
+        return limiter.Result{}, limiter.ErrExceedsDeadline
+    }
+
+    if result.Allowed && delay > 0 {
+        if err := lb.sleep(ctx, delay); err != nil {
+            // The request's own context is done, so take its units out on a fresh one
+            if releaseErr := lb.release(context.Background(), key, r, n); releaseErr != nil {
+                return limiter.Result{}, fmt.Errorf("%w (leakybucket: releasing queued units: %v)", err, releaseErr)
+            }
+            return limiter.Result{}, err
+        }
+    }
+
+    return result, nil
+}
+
+// Check reports the result AllowResult would return for a request costing n units, without adding anything to the
+// bucket. It never waits, even in Queue mode.
+func (lb *LeakyBucket) Check(ctx context.Context, key string, n int) (limiter.Result, error) {
+    if n < 0 {
+        n = 0
+    }
+
+    r, err := lb.rate(ctx, key)
+    if err != nil {
+        return limiter.Result{}, err
+    }
+
+    now := lb.now()
+    b, err := lb.load(ctx, key, r, now)
+    if err != nil {
+        return limiter.Result{}, err
+    }
+
+    result, _ := r.decide(b, now, n)
+    return result, nil
+}
+
+// Reserve reserves n units for a given key. Unlike AllowN, it also reserves room the bucket does not have yet: the
+// units are added regardless, and the reservation's Delay reports when they would have fit into the bucket, or in
+// Queue mode when the units ahead of them have leaked out. It never blocks. The reservation is not OK only if n
+// exceeds the bucket's capacity. Canceling it takes the units out again.
+func (lb *LeakyBucket) Reserve(ctx context.Context, key string, n int) (*limiter.Reservation, error) {
+    if n < 0 {
+        n = 0
+    }
+
+    r, err := lb.rate(ctx, key)
+    if err != nil {
+        return nil, err
+    }
+    if n > r.capacity() {
+        return limiter.NewReservation(false, lb.now().Add(r.interval), nil), nil
+    }
+
+    at, err := lb.reserve(ctx, key, r, n)
+    if err != nil {
+        return nil, err
     }
 
-    if count > maxRequests+burstLimit {
-        return false, nil
+    return limiter.NewReservation(true, at, func(ctx context.Context) error {
+        return lb.release(ctx, key, r, n)
+    }), nil
+}
+
+// Refund credits back n units previously consumed for a given key, for requests that should not count against
+// the limit after all. The units are taken out of the bucket, never below empty.
+func (lb *LeakyBucket) Refund(ctx context.Context, key string, n int) error {
+    if n <= 0 {
+        return nil
     }
 
-    // Simulate leaking by decrementing the count after the interval
-    go func() {
-        time.Sleep(interval)
-        lb.storage.Reset(ctx, key)
-    }()
+    r, err := lb.rate(ctx, key)
+    if err != nil {
+        return err
+    }
+    return lb.release(ctx, key, r, n)
+}
+
+// Charge consumes n units for a given key after the fact, even if they do not fit into the bucket, for costs
+// that are only known once a request has been served. The bucket overflows, delaying later requests accordingly.
+func (lb *LeakyBucket) Charge(ctx context.Context, key string, n int) error {
+    if n <= 0 {
+        return nil
+    }
+
+    r, err := lb.rate(ctx, key)
+    if err != nil {
+        return err
+    }
+    _, err = lb.reserve(ctx, key, r, n)
+    return err
+}
+
+// reserve adds n units to the bucket stored for key regardless of its capacity, and returns the time at which
+// they would have fit, or in Queue mode the time at which the units ahead of them have leaked out.
+func (lb *LeakyBucket) reserve(ctx context.Context, key string, r rate, n int) (time.Time, error) {
+    var at time.Time
+    err := storage.UpdateState(ctx, lb.storage, key, func(state []byte) ([]byte, time.Duration, error) {
+        now := lb.now()
+        b, err := r.drain(state, now)
+        if err != nil {
+            return nil, 0, err
+        }
+
+        at = r.drainedAt(b, r.capacity()-n)
+        if lb.mode == Queue {
+            at = r.drainedAt(b, 0)
+        }
+        if n == 0 {
+            return nil, 0, nil
+        }
+        b.level += n
+        return b.encode(), r.drainedAt(b, 0).Sub(now), nil
+    })
+    return at, err
+}
 
-    return true, nil
+// release takes n units out of the bucket stored for key, for requests that gave up.
+func (lb *LeakyBucket) release(ctx context.Context, key string, r rate, n int) error {
+    return storage.UpdateState(ctx, lb.storage, key, func(state []byte) ([]byte, time.Duration, error) {
+        now := lb.now()
+        b, err := r.drain(state, now)
+        if err != nil || b.level == 0 {
+            return nil, 0, err
+        }
+        b.level -= n
+        if b.level < 0 {
+            b.level = 0
+        }
+        ttl := r.drainedAt(b, 0).Sub(now)
+        if ttl <= 0 {
+            // An empty bucket is the same as none; let it expire right away
+            ttl = time.Nanosecond
+        }
+        return b.encode(), ttl, nil
+    })
 }
 
-// Quota returns the current quota information.
-func (lb *LeakyBucket) Quota(ctx context.Context, key string) (int, int, int, error) {
-    count, err := lb.storage.Get(ctx, key)
+// load returns the key's bucket as of now.
+func (lb *LeakyBucket) load(ctx context.Context, key string, r rate, now time.Time) (bucket, error) {
+    state, err := lb.storage.GetState(ctx, key)
     if err != nil {
-        return 0, 0, 0, err
+        return bucket{}, err
     }
+    return r.drain(state, now)
+}
+
+// Reset clears the usage recorded for a given key, giving it its full quota back. Quota granted to the key is kept.
+func (lb *LeakyBucket) Reset(ctx context.Context, key string) error {
+    return lb.storage.Reset(ctx, key)
+}
+
+// Grant raises the limit of a given key by n units for the given duration, on top of the configured limit and burst.
+// Granting again adds to the current grant and restarts its expiry. See limiter.Grant.
+func (lb *LeakyBucket) Grant(ctx context.Context, key string, n int, ttl time.Duration) error {
+    return limiter.Grant(ctx, lb.storage, key, n, ttl)
+}
 
-    maxRequests, err := lb.config.MaxRequests(ctx)
+// Revoke withdraws any units granted to a given key before the grant expires.
+func (lb *LeakyBucket) Revoke(ctx context.Context, key string) error {
+    return limiter.Revoke(ctx, lb.storage, key)
+}
+
+// Quota returns the current quota information. The window runs from the bucket's last update until it is empty.
+func (lb *LeakyBucket) Quota(ctx context.Context, key string) (limiter.Quota, error) {
+    r, err := lb.rate(ctx, key)
     if err != nil {
-        return 0, 0, 0, err
+        return limiter.Quota{}, err
     }
 
-    burstLimit, err := lb.config.BurstLimit(ctx)
+    b, err := lb.load(ctx, key, r, lb.now())
     if err != nil {
-        return 0, 0, 0, err
+        return limiter.Quota{}, err
     }
 
-    return count, maxRequests, burstLimit, nil
+    return limiter.Quota{
+        Algorithm:   Name,
+        Used:        b.level,
+        Limit:       r.maxRequests,
+        Burst:       r.burstLimit,
+        Granted:     r.granted,
+        Remaining:   r.remaining(b.level),
+        WindowStart: b.lastUpdate,
+        WindowEnd:   r.drainedAt(b, 0),
+    }, nil
 }
 
-// NextAllowed returns the time duration until the next allowed request.
+// NextAllowed returns the time duration until a request of one unit fits into the key's bucket.
 func (lb *LeakyBucket) NextAllowed(ctx context.Context, key string) (time.Duration, error) {
-    ttl, err := lb.storage.TTL(ctx, key)
+    r, err := lb.rate(ctx, key)
+    if err != nil {
+        return 0, err
+    }
+
+    now := lb.now()
+    b, err := lb.load(ctx, key, r, now)
     if err != nil {
         return 0, err
     }
-    return ttl, nil
+
+    if wait := r.drainedAt(b, r.capacity()-1).Sub(now); wait > 0 {
+        return wait, nil
+    }
+    return 0, nil
 }
@@ -2,57 +2,346 @@ package leakybucket
 
 import (
     "context"
+    "errors"
+    "strings"
     "testing"
     "time"
 
     "github.com/umbeluzi/ratelimit/config"
+    "github.com/umbeluzi/ratelimit/internal/clocktest"
+    "github.com/umbeluzi/ratelimit/limiter"
     "github.com/umbeluzi/ratelimit/storage"
 )
 
-type MockStorage struct {
-    count int
+func newTestLimiter(t *testing.T, mode Mode) *LeakyBucket {
+    lb := NewWithMode(clocktest.Storage(t), clocktest.Policy(), mode)
+    clocktest.Pin(&lb.now, 0)
+    return lb
 }
 
-func (ms *MockStorage) Increment(ctx context.Context, key string) (int, error) {
-    ms.count++
-    return ms.count, nil
+func TestLeakyBucket_Allow(t *testing.T) {
+    lb := newTestLimiter(t, Meter)
+
+    for i := 0; i < 9; i++ {
+        allowed, err := lb.Allow(context.Background(), "test")
+        if err != nil {
+            t.Errorf("unexpected error: %v", err)
+        }
+
+        if i < 7 && !allowed {
+            t.Errorf("request %d should be allowed", i+1)
+        }
+
+        if i >= 7 && allowed {
+            t.Errorf("request %d should be denied", i+1)
+        }
+    }
 }
 
-func (ms *MockStorage) Reset(ctx context.Context, key string) error {
-    ms.count = 0
-    return nil
+func TestLeakyBucket_AllowN(t *testing.T) {
+    lb := newTestLimiter(t, Meter)
+
+    steps := []struct {
+        n       int
+        allowed bool
+    }{
+        {5, true},
+        {3, false},
+        {2, true},
+        {1, false},
+    }
+
+    for i, step := range steps {
+        allowed, err := lb.AllowN(context.Background(), "test", step.n)
+        if err != nil {
+            t.Errorf("unexpected error: %v", err)
+        }
+
+        if allowed != step.allowed {
+            t.Errorf("step %d: expected allowed=%v for n=%d, got %v", i+1, step.allowed, step.n, allowed)
+        }
+    }
+
+    quota, _ := lb.Quota(context.Background(), "test")
+    if quota.Used != 7 {
+        t.Errorf("expected denied requests not to consume quota, got %d used", quota.Used)
+    }
 }
 
-func (ms *MockStorage) TTL(ctx context.Context, key string) (time.Duration, error) {
-    return time.Minute, nil
+func TestLeakyBucket_AllowResult(t *testing.T) {
+    lb := newTestLimiter(t, Meter)
+
+    result, err := lb.AllowResult(context.Background(), "test", 5)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if !result.Allowed || result.Limit != 7 || result.Remaining != 2 || result.RetryAfter != 0 {
+        t.Errorf("unexpected result for allowed request: %+v", result)
+    }
+    // Five units leak at 5 per minute
+    if !result.ResetAt.Equal(clocktest.Start.Add(time.Minute)) {
+        t.Errorf("expected ResetAt after 1m, got %s", result.ResetAt)
+    }
+
+    result, err = lb.AllowResult(context.Background(), "test", 3)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if result.Allowed || result.Remaining != 2 {
+        t.Errorf("unexpected result for denied request: %+v", result)
+    }
+    if result.RetryAfter != 12*time.Second {
+        t.Errorf("expected RetryAfter until one unit leaked, got %s", result.RetryAfter)
+    }
 }
 
-func (ms *MockStorage) SetTTL(ctx context.Context, key string, ttl time.Duration) error {
-    return nil
+func TestLeakyBucket_Leak(t *testing.T) {
+    lb := newTestLimiter(t, Meter)
+    ctx := context.Background()
+
+    if allowed, _ := lb.AllowN(ctx, "test", 7); !allowed {
+        t.Fatalf("expected an empty bucket to take 7 units")
+    }
+
+    // One unit leaks every 12s rather than the whole bucket at once
+    clocktest.Pin(&lb.now, 30*time.Second)
+    quota, _ := lb.Quota(ctx, "test")
+    if quota.Used != 5 {
+        t.Errorf("expected 2 units to have leaked after 30s, got level %d", quota.Used)
+    }
+    if allowed, _ := lb.AllowN(ctx, "test", 3); allowed {
+        t.Errorf("expected 3 units not to fit after 30s")
+    }
+    if allowed, _ := lb.AllowN(ctx, "test", 2); !allowed {
+        t.Errorf("expected 2 units to fit after 30s")
+    }
+    next, _ := lb.NextAllowed(ctx, "test")
+    if next != 6*time.Second {
+        t.Errorf("expected the next unit to leak in 6s, got %s", next)
+    }
+
+    clocktest.Pin(&lb.now, time.Hour)
+    quota, _ = lb.Quota(ctx, "test")
+    if quota.Used != 0 || quota.Remaining != 7 {
+        t.Errorf("expected an empty bucket, got %+v", quota)
+    }
 }
 
-func (ms *MockStorage) Get(ctx context.Context, key string) (int, error) {
-    return ms.count, nil
+func TestLeakyBucket_LargeLimit(t *testing.T) {
+    // A million units a day overflows int64 nanoseconds when multiplied naively
+    lb := New(clocktest.Storage(t), config.Limits{Requests: 1000000, Period: 24 * time.Hour})
+    clocktest.Pin(&lb.now, 0)
+    ctx := context.Background()
+
+    result, err := lb.AllowResult(ctx, "test", 1000000)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if !result.Allowed || result.ResetAt != clocktest.Start.Add(24*time.Hour) {
+        t.Errorf("expected the bucket to drain in 24h, got %+v", result)
+    }
+
+    result, _ = lb.AllowResult(ctx, "test", 10)
+    if result.Allowed || result.RetryAfter != 864*time.Millisecond {
+        t.Errorf("expected a full bucket, got %+v", result)
+    }
+
+    clocktest.Pin(&lb.now, 12*time.Hour)
+    if quota, _ := lb.Quota(ctx, "test"); quota.Used != 500000 {
+        t.Errorf("expected half the bucket drained after 12h, got %+v", quota)
+    }
 }
 
-func TestLeakyBucket_Allow(t *testing.T) {
-    storage := &MockStorage{}
-    config := config.NewStatic(5, time.Minute, 2, 0, time.Now())
+func TestLeakyBucket_Queue(t *testing.T) {
+    lb := newTestLimiter(t, Queue)
+    ctx := context.Background()
 
-    lb := New(storage, config)
+    var delays []time.Duration
+    lb.sleep = func(ctx context.Context, d time.Duration) error {
+        delays = append(delays, d)
+        return nil
+    }
 
-    for i := 0; i < 7; i++ {
-        allowed, err := lb.Allow(context.Background(), "test")
+    for i := 0; i < 8; i++ {
+        allowed, err := lb.Allow(ctx, "test")
         if err != nil {
-            t.Errorf("unexpected error: %v", err)
+            t.Fatalf("unexpected error: %v", err)
         }
-
-        if i < 5 && !allowed {
-            t.Errorf("request %d should be allowed", i+1)
+        if i < 7 && !allowed {
+            t.Errorf("request %d should be queued", i+1)
         }
+        if i >= 7 && allowed {
+            t.Errorf("request %d should be rejected by the full queue", i+1)
+        }
+    }
 
-        if i >= 5 && allowed {
-            t.Errorf("request %d should be denied", i+1)
+    // Queued requests leave the bucket one every 12s
+    if len(delays) != 6 {
+        t.Fatalf("expected 6 delayed requests, got %v", delays)
+    }
+    for i, delay := range delays {
+        if want := time.Duration(i+1) * 12 * time.Second; delay != want {
+            t.Errorf("request %d: expected delay %s, got %s", i+2, want, delay)
         }
     }
 }
+
+func TestLeakyBucket_QueueCanceled(t *testing.T) {
+    lb := newTestLimiter(t, Queue)
+    ctx := context.Background()
+
+    // The caller gives up while waiting for its turn
+    lb.sleep = func(ctx context.Context, d time.Duration) error {
+        return context.Canceled
+    }
+
+    lb.Allow(ctx, "test")
+    if _, err := lb.AllowN(ctx, "test", 2); err != context.Canceled {
+        t.Fatalf("expected context.Canceled, got %v", err)
+    }
+
+    quota, _ := lb.Quota(ctx, "test")
+    if quota.Used != 1 {
+        t.Errorf("expected the canceled request to leave the queue, got level %d", quota.Used)
+    }
+}
+
+// failingStorage is a storage whose state updates fail once fail is set.
+type failingStorage struct {
+    storage.StateStorage
+    fail bool
+}
+
+var errStorage = errors.New("storage unavailable")
+
+func (s *failingStorage) CompareAndSwapState(ctx context.Context, key string, old, new []byte, ttl time.Duration) (bool, error) {
+    if s.fail {
+        return false, errStorage
+    }
+    return s.StateStorage.CompareAndSwapState(ctx, key, old, new, ttl)
+}
+
+func TestLeakyBucket_QueueReleaseError(t *testing.T) {
+    storage := &failingStorage{StateStorage: clocktest.Storage(t)}
+    lb := NewWithMode(storage, clocktest.Policy(), Queue)
+    clocktest.Pin(&lb.now, 0)
+    ctx := context.Background()
+
+    // The caller gives up, and the storage fails to take its units out again
+    lb.sleep = func(ctx context.Context, d time.Duration) error {
+        storage.fail = true
+        return context.Canceled
+    }
+
+    lb.Allow(ctx, "test")
+    _, err := lb.Allow(ctx, "test")
+    if !errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), errStorage.Error()) {
+        t.Errorf("expected context.Canceled along with the release error, got %v", err)
+    }
+}
+
+func TestLeakyBucket_QueueDeadline(t *testing.T) {
+    lb := newTestLimiter(t, Queue)
+    lb.sleep = func(ctx context.Context, d time.Duration) error {
+        t.Fatalf("unexpected sleep of %s past the deadline", d)
+        return nil
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+    defer cancel()
+
+    // The first request is served right away, the second would wait 12s
+    if allowed, err := lb.Allow(ctx, "test"); err != nil || !allowed {
+        t.Fatalf("expected the first request to be allowed, got %v, %v", allowed, err)
+    }
+    if _, err := lb.Allow(ctx, "test"); err != limiter.ErrExceedsDeadline {
+        t.Fatalf("expected ErrExceedsDeadline, got %v", err)
+    }
+    if err := lb.Wait(ctx, "test"); err != limiter.ErrExceedsDeadline {
+        t.Fatalf("expected Wait to fail with ErrExceedsDeadline, got %v", err)
+    }
+
+    quota, _ := lb.Quota(context.Background(), "test")
+    if quota.Used != 1 {
+        t.Errorf("expected the late requests not to be queued, got level %d", quota.Used)
+    }
+}
+
+func TestLeakyBucket_Check(t *testing.T) {
+    lb := newTestLimiter(t, Queue)
+    ctx := context.Background()
+
+    lb.sleep = func(ctx context.Context, d time.Duration) error { return nil }
+    lb.AllowN(ctx, "test", 5)
+
+    // Unlike AllowResult, a check never waits for the request's turn, even in Queue mode
+    lb.sleep = func(ctx context.Context, d time.Duration) error {
+        t.Fatalf("unexpected sleep of %s", d)
+        return nil
+    }
+    checked, err := lb.Check(ctx, "test", 2)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if !checked.Allowed || checked.Remaining != 0 || !checked.ResetAt.Equal(clocktest.Start.Add(84*time.Second)) {
+        t.Errorf("unexpected result for allowed check: %+v", checked)
+    }
+
+    if quota, _ := lb.Quota(ctx, "test"); quota.Used != 5 {
+        t.Errorf("expected the check to add nothing to the bucket, got level %d", quota.Used)
+    }
+}
+
+func TestLeakyBucket_Reserve(t *testing.T) {
+    lb := newTestLimiter(t, Meter)
+    ctx := context.Background()
+
+    lb.AllowN(ctx, "test", 6)
+
+    // Units that do not fit yet are reserved until enough has leaked
+    reservation, err := lb.Reserve(ctx, "test", 3)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if !reservation.OK() || reservation.DelayFrom(clocktest.Start) != 24*time.Second {
+        t.Errorf("expected a reservation in 24s, got OK=%v Delay=%s", reservation.OK(), reservation.DelayFrom(clocktest.Start))
+    }
+
+    if err := reservation.Cancel(ctx); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    quota, _ := lb.Quota(ctx, "test")
+    if quota.Used != 6 {
+        t.Errorf("expected canceled units to be taken out, got level %d", quota.Used)
+    }
+
+    // In Queue mode a reservation waits for every unit ahead of it
+    lb = newTestLimiter(t, Queue)
+    lb.sleep = func(ctx context.Context, d time.Duration) error { return nil }
+    lb.AllowN(ctx, "test", 2)
+    reservation, _ = lb.Reserve(ctx, "test", 1)
+    if reservation.DelayFrom(clocktest.Start) != 24*time.Second {
+        t.Errorf("expected a queued reservation in 24s, got %s", reservation.DelayFrom(clocktest.Start))
+    }
+}
+
+func TestLeakyBucket_Quota(t *testing.T) {
+    lb := newTestLimiter(t, Meter)
+
+    for i := 0; i < 3; i++ {
+        lb.Allow(context.Background(), "test")
+    }
+
+    quota, err := lb.Quota(context.Background(), "test")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    if quota.Algorithm != Name || quota.Used != 3 || quota.Limit != 5 || quota.Burst != 2 || quota.Remaining != 4 {
+        t.Errorf("unexpected quota: %+v", quota)
+    }
+
+    if quota.WindowEnd.Sub(quota.WindowStart) != 36*time.Second {
+        t.Errorf("expected the bucket to drain in 36s, got %s to %s", quota.WindowStart, quota.WindowEnd)
+    }
+}
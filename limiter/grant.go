@@ -0,0 +1,40 @@
+package limiter
+
+import (
+    "context"
+    "time"
+
+    "github.com/umbeluzi/ratelimit/storage"
+)
+
+// grantPrefix starts the storage keys holding grants. Its leading NUL byte keeps grants apart from the keys callers
+// limit, which must not start with a NUL byte themselves; otherwise limiting a key such as "alice:grant" would
+// count towards alice's grant.
+const grantPrefix = "\x00grant:"
+
+// GrantKey returns the storage key holding the extra units granted for key.
+func GrantKey(key string) string {
+    return grantPrefix + key
+}
+
+// Grant raises the limit of key by n units for the given duration, for example to unblock a customer during an
+// incident. The grant is kept as a counter under GrantKey(key), so every limiter sharing the storage honours it.
+// Granting again adds to the current grant and restarts its expiry; both are written in one step through
+// storage.IncrementByAndRenew, so a grant is never left without an expiry. A non-positive n or ttl grants nothing.
+func Grant(ctx context.Context, s storage.Storage, key string, n int, ttl time.Duration) error {
+    if n <= 0 || ttl <= 0 {
+        return nil
+    }
+    _, err := storage.IncrementByAndRenew(ctx, s, GrantKey(key), n, ttl)
+    return err
+}
+
+// Granted returns the number of extra units currently granted for key.
+func Granted(ctx context.Context, s storage.Storage, key string) (int, error) {
+    return s.Get(ctx, GrantKey(key))
+}
+
+// Revoke withdraws any extra units granted for key before the grant expires.
+func Revoke(ctx context.Context, s storage.Storage, key string) error {
+    return s.Reset(ctx, GrantKey(key))
+}
@@ -0,0 +1,83 @@
+package limiter
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/umbeluzi/ratelimit/storage"
+)
+
+func TestGrant(t *testing.T) {
+    s := storage.NewInMemoryStorageWithSweepInterval(0)
+    defer s.Close()
+    ctx := context.Background()
+
+    if err := Grant(ctx, s, "test", 1000, time.Hour); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if err := Grant(ctx, s, "test", 500, time.Minute); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    granted, err := Granted(ctx, s, "test")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if granted != 1500 {
+        t.Errorf("expected grants to add up to 1500, got %d", granted)
+    }
+    if ttl, _ := s.TTL(ctx, GrantKey("test")); ttl <= 59*time.Second || ttl > time.Minute {
+        t.Errorf("expected granting again to restart the expiry at 1m, got %s", ttl)
+    }
+
+    // Non-positive grants change nothing
+    Grant(ctx, s, "test", -1, time.Hour)
+    Grant(ctx, s, "test", 1, 0)
+    if granted, _ := Granted(ctx, s, "test"); granted != 1500 {
+        t.Errorf("expected non-positive grants to be ignored, got %d", granted)
+    }
+
+    if err := Revoke(ctx, s, "test"); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if granted, _ := Granted(ctx, s, "test"); granted != 0 {
+        t.Errorf("expected no grant after revoking, got %d", granted)
+    }
+}
+
+func TestGrant_Expiry(t *testing.T) {
+    s := storage.NewInMemoryStorageWithSweepInterval(0)
+    defer s.Close()
+    ctx := context.Background()
+
+    Grant(ctx, s, "test", 10, 10*time.Millisecond)
+    time.Sleep(20 * time.Millisecond)
+
+    if granted, _ := Granted(ctx, s, "test"); granted != 0 {
+        t.Errorf("expected the grant to expire, got %d", granted)
+    }
+}
+
+// noSetTTLStorage is a storage whose SetTTL always fails.
+type noSetTTLStorage struct {
+    *storage.InMemoryStorage
+}
+
+func (noSetTTLStorage) SetTTL(ctx context.Context, key string, ttl time.Duration) error {
+    return errors.New("SetTTL unavailable")
+}
+
+func TestGrant_Atomic(t *testing.T) {
+    s := noSetTTLStorage{storage.NewInMemoryStorageWithSweepInterval(0)}
+    defer s.Close()
+    ctx := context.Background()
+
+    // The grant and its expiry are written in one step, without a separate SetTTL
+    if err := Grant(ctx, s, "test", 10, time.Hour); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if ttl, _ := s.TTL(ctx, GrantKey("test")); ttl <= 59*time.Minute || ttl > time.Hour {
+        t.Errorf("expected the grant to expire in 1h, got %s", ttl)
+    }
+}
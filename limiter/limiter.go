@@ -0,0 +1,38 @@
+// Package limiter holds the types shared by the rate limiting algorithms.
+package limiter
+
+import "time"
+
+// Result describes the outcome of a single rate limiting decision.
+type Result struct {
+    // Allowed reports whether the request is allowed.
+    Allowed bool
+    // Limit is the maximum number of requests the key may make at once, including any granted ones.
+    Limit int
+    // Remaining is the number of requests the key may still make after this decision.
+    Remaining int
+    // ResetAt is the time at which the key's quota is fully replenished.
+    ResetAt time.Time
+    // RetryAfter is how long to wait before retrying a denied request. It is zero for allowed requests.
+    RetryAfter time.Duration
+}
+
+// Quota describes how much of a key's quota has been used. It is returned by the Quota method of every algorithm.
+type Quota struct {
+    // Algorithm is the name of the algorithm that produced the quota.
+    Algorithm string `json:"algorithm"`
+    // Used is the number of requests counted against the key.
+    Used int `json:"used"`
+    // Limit is the configured number of requests per interval.
+    Limit int `json:"limit"`
+    // Burst is the number of requests allowed on top of Limit.
+    Burst int `json:"burst"`
+    // Granted is the number of requests temporarily granted to the key on top of Limit and Burst.
+    Granted int `json:"granted,omitempty"`
+    // Remaining is the number of requests the key may still make.
+    Remaining int `json:"remaining"`
+    // WindowStart is the start of the period the usage is counted over.
+    WindowStart time.Time `json:"window_start"`
+    // WindowEnd is the end of the period the usage is counted over.
+    WindowEnd time.Time `json:"window_end"`
+}
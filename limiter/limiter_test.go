@@ -0,0 +1,38 @@
+package limiter
+
+import (
+    "encoding/json"
+    "testing"
+    "time"
+)
+
+func TestQuota_JSON(t *testing.T) {
+    start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+    quota := Quota{
+        Algorithm:   "fixedwindow",
+        Used:        3,
+        Limit:       5,
+        Burst:       2,
+        Remaining:   4,
+        WindowStart: start,
+        WindowEnd:   start.Add(time.Minute),
+    }
+
+    data, err := json.Marshal(quota)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    want := `{"algorithm":"fixedwindow","used":3,"limit":5,"burst":2,"remaining":4,"window_start":"2024-01-01T12:00:00Z","window_end":"2024-01-01T12:01:00Z"}`
+    if string(data) != want {
+        t.Errorf("expected %s, got %s", want, data)
+    }
+
+    var decoded Quota
+    if err := json.Unmarshal(data, &decoded); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if decoded != quota {
+        t.Errorf("expected %+v after round-trip, got %+v", quota, decoded)
+    }
+}
@@ -0,0 +1,60 @@
+package limiter
+
+import (
+    "context"
+    "sync"
+    "time"
+)
+
+// Reservation holds units reserved by a limiter's Reserve method. Reserved units are consumed as soon as the
+// reservation is made; the caller must wait for Delay before acting on it, or Cancel it to return the units.
+type Reservation struct {
+    ok     bool
+    at     time.Time
+    cancel func(ctx context.Context) error
+
+    mu       sync.Mutex
+    canceled bool
+}
+
+// NewReservation creates a Reservation that can be acted on at the given time. If ok is false, nothing was
+// reserved and at is the earliest time a new reservation may succeed. cancel returns the reserved units;
+// it may be nil if there is nothing to return.
+func NewReservation(ok bool, at time.Time, cancel func(ctx context.Context) error) *Reservation {
+    return &Reservation{ok: ok, at: at, cancel: cancel}
+}
+
+// OK reports whether the units were reserved. A reservation that is not OK consumed nothing.
+func (r *Reservation) OK() bool {
+    return r.ok
+}
+
+// Delay returns how long to wait before acting on the reservation. For a reservation that is not OK, it returns
+// how long to wait before trying again.
+func (r *Reservation) Delay() time.Duration {
+    return r.DelayFrom(time.Now())
+}
+
+// DelayFrom is like Delay but measures the delay from the given time.
+func (r *Reservation) DelayFrom(now time.Time) time.Duration {
+    if delay := r.at.Sub(now); delay > 0 {
+        return delay
+    }
+    return 0
+}
+
+// Cancel returns the reserved units, so that the caller can roll back when it gives up. It does nothing for a
+// reservation that is not OK or was already canceled.
+func (r *Reservation) Cancel(ctx context.Context) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if !r.ok || r.canceled || r.cancel == nil {
+        return nil
+    }
+    if err := r.cancel(ctx); err != nil {
+        return err
+    }
+    r.canceled = true
+    return nil
+}
@@ -0,0 +1,65 @@
+package limiter
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+)
+
+func TestReservation(t *testing.T) {
+    now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+    var refunds int
+    r := NewReservation(true, now.Add(time.Second), func(ctx context.Context) error {
+        refunds++
+        return nil
+    })
+    if !r.OK() {
+        t.Errorf("expected reservation to be OK")
+    }
+    if delay := r.DelayFrom(now); delay != time.Second {
+        t.Errorf("expected delay 1s, got %s", delay)
+    }
+    if delay := r.DelayFrom(now.Add(time.Minute)); delay != 0 {
+        t.Errorf("expected no delay once the time to act has passed, got %s", delay)
+    }
+
+    for i := 0; i < 2; i++ {
+        if err := r.Cancel(context.Background()); err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+    }
+    if refunds != 1 {
+        t.Errorf("expected units to be returned once, got %d", refunds)
+    }
+}
+
+func TestReservation_NotOK(t *testing.T) {
+    r := NewReservation(false, time.Now().Add(time.Minute), func(ctx context.Context) error {
+        return errors.New("nothing to return")
+    })
+    if r.OK() {
+        t.Errorf("expected reservation not to be OK")
+    }
+    if err := r.Cancel(context.Background()); err != nil {
+        t.Errorf("expected Cancel to do nothing, got %v", err)
+    }
+}
+
+func TestReservation_CancelError(t *testing.T) {
+    fail := true
+    r := NewReservation(true, time.Now(), func(ctx context.Context) error {
+        if fail {
+            return errors.New("storage unavailable")
+        }
+        return nil
+    })
+    if err := r.Cancel(context.Background()); err == nil {
+        t.Fatalf("expected the error to be returned")
+    }
+    fail = false
+    if err := r.Cancel(context.Background()); err != nil {
+        t.Errorf("expected a failed Cancel to be retryable, got %v", err)
+    }
+}
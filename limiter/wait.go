@@ -0,0 +1,57 @@
+package limiter
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+)
+
+var (
+    // ErrExceedsLimit is returned by Wait when a request costs more than the limit and can never be allowed.
+    ErrExceedsLimit = errors.New("limiter: request exceeds limit")
+    // ErrExceedsDeadline is returned by Wait when a request would not be allowed before the context's deadline.
+    ErrExceedsDeadline = errors.New("limiter: wait would exceed context deadline")
+)
+
+// minRetry is the shortest Wait sleeps between attempts, so that a limiter reporting no RetryAfter is not polled in a busy loop.
+const minRetry = time.Millisecond
+
+// Wait blocks until allow admits a request costing n units or ctx is done. allow makes one rate limiting decision,
+// typically the limiter's AllowResult; after a denial, Wait sleeps for the reported RetryAfter and tries again.
+// Like golang.org/x/time/rate, it fails immediately with ErrExceedsLimit if n exceeds the limit, and with
+// ErrExceedsDeadline if the request would not be allowed before ctx's deadline, rather than sleeping in vain.
+func Wait(ctx context.Context, n int, allow func(ctx context.Context) (Result, error)) error {
+    for {
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+
+        result, err := allow(ctx)
+        if err != nil {
+            return err
+        }
+        if result.Allowed {
+            return nil
+        }
+        if n > result.Limit {
+            return fmt.Errorf("%w: %d > %d", ErrExceedsLimit, n, result.Limit)
+        }
+
+        delay := result.RetryAfter
+        if delay < minRetry {
+            delay = minRetry
+        }
+        if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+            return ErrExceedsDeadline
+        }
+
+        timer := time.NewTimer(delay)
+        select {
+        case <-timer.C:
+        case <-ctx.Done():
+            timer.Stop()
+            return ctx.Err()
+        }
+    }
+}
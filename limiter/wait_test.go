@@ -0,0 +1,67 @@
+package limiter
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+)
+
+// denyFor returns an allow function that denies requests until the given number of attempts has been made.
+func denyFor(attempts int, retryAfter time.Duration, calls *int) func(ctx context.Context) (Result, error) {
+    return func(ctx context.Context) (Result, error) {
+        *calls++
+        if *calls <= attempts {
+            return Result{Limit: 5, RetryAfter: retryAfter}, nil
+        }
+        return Result{Allowed: true, Limit: 5}, nil
+    }
+}
+
+func TestWait(t *testing.T) {
+    var calls int
+    begin := time.Now()
+    if err := Wait(context.Background(), 1, denyFor(2, 10*time.Millisecond, &calls)); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if calls != 3 {
+        t.Errorf("expected 3 attempts, got %d", calls)
+    }
+    if elapsed := time.Since(begin); elapsed < 20*time.Millisecond {
+        t.Errorf("expected Wait to sleep for RetryAfter between attempts, took %s", elapsed)
+    }
+}
+
+func TestWait_ExceedsLimit(t *testing.T) {
+    var calls int
+    err := Wait(context.Background(), 6, denyFor(1, time.Second, &calls))
+    if !errors.Is(err, ErrExceedsLimit) {
+        t.Errorf("expected ErrExceedsLimit, got %v", err)
+    }
+}
+
+func TestWait_ExceedsDeadline(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+    defer cancel()
+
+    var calls int
+    begin := time.Now()
+    err := Wait(ctx, 1, denyFor(1, time.Second, &calls))
+    if !errors.Is(err, ErrExceedsDeadline) {
+        t.Errorf("expected ErrExceedsDeadline, got %v", err)
+    }
+    if elapsed := time.Since(begin); elapsed >= 50*time.Millisecond {
+        t.Errorf("expected Wait to fail immediately, took %s", elapsed)
+    }
+}
+
+func TestWait_Canceled(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    time.AfterFunc(10*time.Millisecond, cancel)
+
+    var calls int
+    err := Wait(ctx, 1, denyFor(100, time.Second, &calls))
+    if !errors.Is(err, context.Canceled) {
+        t.Errorf("expected context.Canceled, got %v", err)
+    }
+}
@@ -0,0 +1,117 @@
+// Package ratelimit provides a common interface over the rate limiting algorithms in this module.
+package ratelimit
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/umbeluzi/ratelimit/config"
+    "github.com/umbeluzi/ratelimit/fixedwindow"
+    "github.com/umbeluzi/ratelimit/gcra"
+    "github.com/umbeluzi/ratelimit/leakybucket"
+    "github.com/umbeluzi/ratelimit/limiter"
+    "github.com/umbeluzi/ratelimit/slidinglog"
+    "github.com/umbeluzi/ratelimit/slidingwindow"
+    "github.com/umbeluzi/ratelimit/storage"
+    "github.com/umbeluzi/ratelimit/tokenbucket"
+)
+
+// Names of the algorithms accepted by New.
+const (
+    FixedWindow   = fixedwindow.Name
+    GCRA          = gcra.Name
+    LeakyBucket   = leakybucket.Name
+    SlidingLog    = slidinglog.Name
+    SlidingWindow = slidingwindow.Name
+    TokenBucket   = tokenbucket.Name
+)
+
+// Result describes the outcome of a single rate limiting decision.
+type Result = limiter.Result
+
+// Quota describes how much of a key's quota has been used.
+type Quota = limiter.Quota
+
+// Reservation holds units reserved by Reserve.
+type Reservation = limiter.Reservation
+
+// ErrUnknownAlgorithm is returned by New when the algorithm name is not recognized.
+var ErrUnknownAlgorithm = errors.New("ratelimit: unknown algorithm")
+
+// ErrUnsupportedStorage is returned by New when the storage lacks operations the algorithm needs.
+var ErrUnsupportedStorage = errors.New("ratelimit: storage not supported by algorithm")
+
+// Errors returned by Wait and WaitN.
+var (
+    ErrExceedsLimit    = limiter.ErrExceedsLimit
+    ErrExceedsDeadline = limiter.ErrExceedsDeadline
+)
+
+var (
+    _ Limiter = (*fixedwindow.FixedWindow)(nil)
+    _ Limiter = (*gcra.GCRA)(nil)
+    _ Limiter = (*leakybucket.LeakyBucket)(nil)
+    _ Limiter = (*slidinglog.SlidingLog)(nil)
+    _ Limiter = (*slidingwindow.SlidingWindow)(nil)
+    _ Limiter = (*tokenbucket.TokenBucket)(nil)
+    _ Limiter = (*Router)(nil)
+)
+
+// Limiter is the interface implemented by all rate limiting algorithms.
+type Limiter interface {
+    Allow(ctx context.Context, key string) (bool, error)
+    AllowN(ctx context.Context, key string, n int) (bool, error)
+    AllowResult(ctx context.Context, key string, n int) (Result, error)
+    Check(ctx context.Context, key string, n int) (Result, error)
+    Wait(ctx context.Context, key string) error
+    WaitN(ctx context.Context, key string, n int) error
+    Reserve(ctx context.Context, key string, n int) (*Reservation, error)
+    Refund(ctx context.Context, key string, n int) error
+    Charge(ctx context.Context, key string, n int) error
+    Reset(ctx context.Context, key string) error
+    Grant(ctx context.Context, key string, n int, ttl time.Duration) error
+    Revoke(ctx context.Context, key string) error
+    Quota(ctx context.Context, key string) (Quota, error)
+    NextAllowed(ctx context.Context, key string) (time.Duration, error)
+}
+
+// New creates a new Limiter using the algorithm with the given name.
+// The sliding window log algorithm requires a storage implementing storage.SortedSetStorage, and the generic
+// cell rate, leaky bucket and token bucket algorithms one implementing storage.StateStorage.
+// Leaky buckets created by New run in leakybucket.Meter mode.
+func New(algorithm string, store storage.Storage, policy config.Policy) (Limiter, error) {
+    switch algorithm {
+    case FixedWindow:
+        return fixedwindow.New(store, policy), nil
+    case GCRA:
+        states, ok := store.(storage.StateStorage)
+        if !ok {
+            return nil, fmt.Errorf("%w: %s requires storage.StateStorage", ErrUnsupportedStorage, algorithm)
+        }
+        return gcra.New(states, policy), nil
+    case LeakyBucket:
+        states, ok := store.(storage.StateStorage)
+        if !ok {
+            return nil, fmt.Errorf("%w: %s requires storage.StateStorage", ErrUnsupportedStorage, algorithm)
+        }
+        return leakybucket.New(states, policy), nil
+    case SlidingLog:
+        sortedSets, ok := store.(storage.SortedSetStorage)
+        if !ok {
+            return nil, fmt.Errorf("%w: %s requires storage.SortedSetStorage", ErrUnsupportedStorage, algorithm)
+        }
+        return slidinglog.New(sortedSets, policy), nil
+    case SlidingWindow:
+        return slidingwindow.New(store, policy), nil
+    case TokenBucket:
+        states, ok := store.(storage.StateStorage)
+        if !ok {
+            return nil, fmt.Errorf("%w: %s requires storage.StateStorage", ErrUnsupportedStorage, algorithm)
+        }
+        return tokenbucket.New(states, policy), nil
+    default:
+        return nil, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, algorithm)
+    }
+}
@@ -0,0 +1,428 @@
+package ratelimit
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "testing"
+    "time"
+
+    "github.com/umbeluzi/ratelimit/config"
+    "github.com/umbeluzi/ratelimit/fixedwindow"
+    "github.com/umbeluzi/ratelimit/gcra"
+    "github.com/umbeluzi/ratelimit/leakybucket"
+    "github.com/umbeluzi/ratelimit/slidinglog"
+    "github.com/umbeluzi/ratelimit/slidingwindow"
+    "github.com/umbeluzi/ratelimit/storage"
+    "github.com/umbeluzi/ratelimit/tokenbucket"
+)
+
+func TestNew(t *testing.T) {
+    tests := []struct {
+        algorithm string
+        want      Limiter
+    }{
+        {FixedWindow, &fixedwindow.FixedWindow{}},
+        {GCRA, &gcra.GCRA{}},
+        {LeakyBucket, &leakybucket.LeakyBucket{}},
+        {SlidingLog, &slidinglog.SlidingLog{}},
+        {SlidingWindow, &slidingwindow.SlidingWindow{}},
+        {TokenBucket, &tokenbucket.TokenBucket{}},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.algorithm, func(t *testing.T) {
+            store := storage.NewInMemoryStorage()
+            defer store.Close()
+
+            limiter, err := New(tt.algorithm, store, config.NewStatic(5, time.Minute, 2, 0, time.Now()))
+            if err != nil {
+                t.Fatalf("unexpected error: %v", err)
+            }
+
+            if got, want := fmt.Sprintf("%T", limiter), fmt.Sprintf("%T", tt.want); got != want {
+                t.Errorf("expected %s, got %s", want, got)
+            }
+
+            allowed, err := limiter.Allow(context.Background(), "test")
+            if err != nil {
+                t.Fatalf("unexpected error: %v", err)
+            }
+            if !allowed {
+                t.Errorf("expected first request to be allowed")
+            }
+        })
+    }
+}
+
+func TestNew_UnknownAlgorithm(t *testing.T) {
+    _, err := New("unknown", storage.NewInMemoryStorage(), config.NewStatic(5, time.Minute, 2, 0, time.Now()))
+    if !errors.Is(err, ErrUnknownAlgorithm) {
+        t.Errorf("expected ErrUnknownAlgorithm, got %v", err)
+    }
+}
+
+func TestNew_UnsupportedStorage(t *testing.T) {
+    store := struct{ storage.Storage }{storage.NewInMemoryStorage()}
+    for _, algorithm := range []string{GCRA, LeakyBucket, SlidingLog, TokenBucket} {
+        _, err := New(algorithm, store, config.NewStatic(5, time.Minute, 2, 0, time.Now()))
+        if !errors.Is(err, ErrUnsupportedStorage) {
+            t.Errorf("%s: expected ErrUnsupportedStorage, got %v", algorithm, err)
+        }
+    }
+}
+
+// algorithms lists every algorithm supported by New.
+var algorithms = []string{FixedWindow, GCRA, LeakyBucket, SlidingLog, SlidingWindow, TokenBucket}
+
+func TestAlgorithms(t *testing.T) {
+    if fmt.Sprint(config.Algorithms) != fmt.Sprint(algorithms) {
+        t.Errorf("expected config.Algorithms to be %v, got %v", algorithms, config.Algorithms)
+    }
+}
+
+// forEachAlgorithm runs fn as a subtest for every algorithm, against a limiter enforcing policy with its own
+// in-memory storage.
+func forEachAlgorithm(t *testing.T, policy config.Policy, fn func(t *testing.T, limiter Limiter)) {
+    for _, algorithm := range algorithms {
+        t.Run(algorithm, func(t *testing.T) {
+            store := storage.NewInMemoryStorage()
+            t.Cleanup(func() { store.Close() })
+
+            limiter, err := New(algorithm, store, policy)
+            if err != nil {
+                t.Fatalf("unexpected error: %v", err)
+            }
+            fn(t, limiter)
+        })
+    }
+}
+
+func TestLimiter_Wait(t *testing.T) {
+    forEachAlgorithm(t, config.NewStatic(2, 100*time.Millisecond, 0, 0, time.Now()), func(t *testing.T, limiter Limiter) {
+        ctx := context.Background()
+        if allowed, _ := limiter.AllowN(ctx, "test", 2); !allowed {
+            t.Fatalf("expected the first two requests to be allowed")
+        }
+
+        short, cancel := context.WithTimeout(ctx, time.Millisecond)
+        defer cancel()
+        if err := limiter.Wait(short, "test"); !errors.Is(err, ErrExceedsDeadline) {
+            t.Errorf("expected ErrExceedsDeadline, got %v", err)
+        }
+
+        if err := limiter.WaitN(ctx, "test", 3); !errors.Is(err, ErrExceedsLimit) {
+            t.Errorf("expected ErrExceedsLimit, got %v", err)
+        }
+
+        long, cancel := context.WithTimeout(ctx, time.Second)
+        defer cancel()
+        begin := time.Now()
+        if err := limiter.Wait(long, "test"); err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+        if elapsed := time.Since(begin); elapsed < 10*time.Millisecond {
+            t.Errorf("expected Wait to block until capacity is available, returned after %s", elapsed)
+        }
+    })
+}
+
+func TestLimiter_Reserve(t *testing.T) {
+    forEachAlgorithm(t, config.NewStatic(5, time.Minute, 2, 0, time.Now()), func(t *testing.T, limiter Limiter) {
+        ctx := context.Background()
+        reservation, err := limiter.Reserve(ctx, "test", 7)
+        if err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+        if !reservation.OK() || reservation.Delay() != 0 {
+            t.Fatalf("expected an immediate reservation, got OK=%v Delay=%s", reservation.OK(), reservation.Delay())
+        }
+        if allowed, _ := limiter.Allow(ctx, "test"); allowed {
+            t.Errorf("expected reserved units to be consumed")
+        }
+
+        if err := reservation.Cancel(ctx); err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+        if allowed, _ := limiter.AllowN(ctx, "test", 7); !allowed {
+            t.Errorf("expected canceled units to be returned")
+        }
+
+        reservation, err = limiter.Reserve(ctx, "test", 8)
+        if err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+        if reservation.OK() {
+            t.Errorf("expected a reservation exceeding the limit not to be OK")
+        }
+    })
+}
+
+func TestLimiter_RefundCharge(t *testing.T) {
+    forEachAlgorithm(t, config.NewStatic(5, time.Minute, 2, 0, time.Now()), func(t *testing.T, limiter Limiter) {
+        ctx := context.Background()
+        limiter.AllowN(ctx, "test", 7)
+
+        if err := limiter.Refund(ctx, "test", 2); err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+        if allowed, _ := limiter.AllowN(ctx, "test", 2); !allowed {
+            t.Errorf("expected refunded units to be available again")
+        }
+
+        // Charging is applied even beyond the limit
+        if err := limiter.Charge(ctx, "test", 10); err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+        quota, _ := limiter.Quota(ctx, "test")
+        if quota.Remaining != 0 {
+            t.Errorf("expected no quota left after charging, got %+v", quota)
+        }
+
+        // Refunds never go below an unused key
+        if err := limiter.Refund(ctx, "test", 100); err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+        if allowed, _ := limiter.AllowN(ctx, "test", 7); !allowed {
+            t.Errorf("expected the whole quota to be available after refunding everything")
+        }
+        if allowed, _ := limiter.Allow(ctx, "test"); allowed {
+            t.Errorf("expected a refund not to credit more than was used")
+        }
+    })
+}
+
+func TestLimiter_Check(t *testing.T) {
+    forEachAlgorithm(t, config.NewStatic(5, time.Minute, 2, 0, time.Now()), func(t *testing.T, limiter Limiter) {
+        ctx := context.Background()
+        limiter.AllowN(ctx, "test", 3)
+
+        // Checking consumes nothing, so every check, however often repeated, matches the decision made right after it
+        steps := []struct {
+            n         int
+            allowed   bool
+            remaining int
+        }{
+            {5, false, 4},
+            {2, true, 2},
+            {0, true, 2},
+            {2, true, 0},
+            {1, false, 0},
+        }
+        for _, step := range steps {
+            for i := 0; i < 2; i++ {
+                checked, err := limiter.Check(ctx, "test", step.n)
+                if err != nil {
+                    t.Fatalf("unexpected error: %v", err)
+                }
+                if checked.Allowed != step.allowed || checked.Limit != 7 || checked.Remaining != step.remaining {
+                    t.Errorf("Check(%d): expected allowed %v with %d remaining, got %+v", step.n, step.allowed, step.remaining, checked)
+                }
+            }
+
+            result, err := limiter.AllowResult(ctx, "test", step.n)
+            if err != nil {
+                t.Fatalf("unexpected error: %v", err)
+            }
+            if result.Allowed != step.allowed || result.Limit != 7 || result.Remaining != step.remaining {
+                t.Errorf("AllowResult(%d): expected allowed %v with %d remaining, got %+v", step.n, step.allowed, step.remaining, result)
+            }
+        }
+    })
+}
+
+func TestLimiter_ResetGrant(t *testing.T) {
+    forEachAlgorithm(t, config.NewStatic(5, time.Minute, 2, 0, time.Now()), func(t *testing.T, limiter Limiter) {
+        ctx := context.Background()
+        limiter.AllowN(ctx, "test", 7)
+        if allowed, _ := limiter.Allow(ctx, "test"); allowed {
+            t.Fatalf("expected the quota to be used up")
+        }
+
+        if err := limiter.Grant(ctx, "test", 3, time.Hour); err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+        if allowed, _ := limiter.AllowN(ctx, "test", 3); !allowed {
+            t.Errorf("expected granted units to be available")
+        }
+        if allowed, _ := limiter.Allow(ctx, "test"); allowed {
+            t.Errorf("expected no more than the granted units to be available")
+        }
+        if quota, _ := limiter.Quota(ctx, "test"); quota.Granted != 3 {
+            t.Errorf("expected the quota to report 3 granted units, got %+v", quota)
+        }
+
+        if err := limiter.Revoke(ctx, "test"); err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+        if quota, _ := limiter.Quota(ctx, "test"); quota.Granted != 0 {
+            t.Errorf("expected no granted units after revoking, got %+v", quota)
+        }
+
+        if err := limiter.Reset(ctx, "test"); err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+        if allowed, _ := limiter.AllowN(ctx, "test", 7); !allowed {
+            t.Errorf("expected the whole quota to be available after a reset")
+        }
+        if allowed, _ := limiter.Allow(ctx, "test"); allowed {
+            t.Errorf("expected a reset not to raise the limit")
+        }
+
+        // Units used while a grant lasted are not paid back once it is revoked or expires
+        for _, end := range []string{"revoked", "expired"} {
+            ttl := time.Hour
+            if end == "expired" {
+                ttl = 50 * time.Millisecond
+            }
+            if err := limiter.Grant(ctx, end, 1000, ttl); err != nil {
+                t.Fatalf("unexpected error: %v", err)
+            }
+            if allowed, _ := limiter.AllowN(ctx, end, 1000); !allowed {
+                t.Errorf("expected granted units to be available")
+            }
+            if end == "revoked" {
+                if err := limiter.Revoke(ctx, end); err != nil {
+                    t.Fatalf("unexpected error: %v", err)
+                }
+            } else {
+                time.Sleep(100 * time.Millisecond)
+            }
+            if next, _ := limiter.NextAllowed(ctx, end); next > 2*time.Minute {
+                t.Errorf("expected the key to be limited by its configured rate once the grant was %s, got %s", end, next)
+            }
+        }
+    })
+}
+
+func TestLimiter_GrantKey(t *testing.T) {
+    forEachAlgorithm(t, config.NewStatic(5, time.Minute, 2, 0, time.Now()), func(t *testing.T, limiter Limiter) {
+        ctx := context.Background()
+
+        // Keys that look like they hold a grant are limited like any other key
+        if allowed, _ := limiter.AllowN(ctx, "test:grant", 5); !allowed {
+            t.Fatalf("expected the requests to be allowed")
+        }
+
+        quota, err := limiter.Quota(ctx, "test")
+        if err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+        if quota.Granted != 0 || quota.Remaining != 7 {
+            t.Errorf("expected limiting test:grant to leave the quota of test alone, got %+v", quota)
+        }
+    })
+}
+
+func TestLimiter_Resolver(t *testing.T) {
+    resolver := config.MapResolver{
+        Keys:      map[string]config.Limits{"acme": {Requests: 10, Period: time.Minute}},
+        Attribute: "plan",
+        Tiers:     map[string]config.Limits{"pro": {Requests: 5, Period: time.Minute, Burst: 2}},
+        Default:   config.Limits{Requests: 2, Period: time.Minute},
+    }
+
+    forEachAlgorithm(t, resolver, func(t *testing.T, limiter Limiter) {
+        free := context.Background()
+        pro := config.WithAttributes(free, config.Attributes{"plan": "pro"})
+        tests := []struct {
+            ctx   context.Context
+            key   string
+            limit int
+        }{
+            {free, "initech", 2},
+            {pro, "globex", 7},
+            {pro, "acme", 10},
+        }
+        for _, tt := range tests {
+            result, err := limiter.AllowResult(tt.ctx, tt.key, tt.limit)
+            if err != nil {
+                t.Fatalf("unexpected error: %v", err)
+            }
+            if !result.Allowed || result.Limit != tt.limit {
+                t.Errorf("%s: expected a limit of %d, got %+v", tt.key, tt.limit, result)
+            }
+            if allowed, _ := limiter.Allow(tt.ctx, tt.key); allowed {
+                t.Errorf("%s: expected requests beyond the limit to be denied", tt.key)
+            }
+        }
+    })
+}
+
+func TestLimiter_Rules(t *testing.T) {
+    rules, err := config.ParseRules([]byte(`
+rules:
+  - key: "search:*"
+    algorithm: gcra
+    limit: 3
+    interval: 1m
+  - key: "*"
+    algorithm: fixedwindow
+    limit: 5
+    interval: 1m
+    burst: 2
+`))
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    store := storage.NewInMemoryStorage()
+    defer store.Close()
+    ctx := context.Background()
+
+    for key, limit := range map[string]int{"search:alice": 3, "checkout:alice": 7} {
+        rule, ok := rules.Match(key)
+        if !ok {
+            t.Fatalf("expected a rule for %s", key)
+        }
+        limiter, err := New(rule.Algorithm, store, rules)
+        if err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+
+        if allowed, _ := limiter.AllowN(ctx, key, limit); !allowed {
+            t.Errorf("%s: expected %d requests to be allowed", key, limit)
+        }
+        if allowed, _ := limiter.Allow(ctx, key); allowed {
+            t.Errorf("%s: expected requests beyond %d to be denied", key, limit)
+        }
+    }
+}
+
+func TestLimiter_Reload(t *testing.T) {
+    before := config.Limits{Requests: 5, Period: time.Minute}
+    policy, err := config.NewReloadable(before)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    forEachAlgorithm(t, policy, func(t *testing.T, limiter Limiter) {
+        if err := policy.Reload(before); err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+
+        ctx := context.Background()
+        limiter.AllowN(ctx, "test", 5)
+        if allowed, _ := limiter.Allow(ctx, "test"); allowed {
+            t.Fatalf("expected the quota to be used up")
+        }
+
+        if err := policy.Reload(config.Limits{Requests: 5, Period: time.Minute, Burst: 2}); err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+
+        // The usage recorded before the reload still counts against the new limit. A token bucket keeps its
+        // tokens, so its larger capacity only fills up as tokens are refilled.
+        want := 2
+        if _, ok := limiter.(*tokenbucket.TokenBucket); ok {
+            want = 0
+        }
+        result, err := limiter.Check(ctx, "test", 0)
+        if err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+        if result.Limit != 7 || result.Remaining != want {
+            t.Errorf("expected a limit of 7 with %d remaining after the reload, got %+v", want, result)
+        }
+    })
+}
@@ -0,0 +1,165 @@
+package ratelimit
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "github.com/umbeluzi/ratelimit/config"
+    "github.com/umbeluzi/ratelimit/storage"
+)
+
+// Router is a Limiter enforcing a rule file: each key is limited by the algorithm named by the first rule matching
+// it, with that rule's limits. Keys matching no rule fail with config.ErrNoLimits.
+type Router struct {
+    rules    *config.Rules
+    limiters map[string]Limiter
+}
+
+// NewRouter creates a Router enforcing rules, with one limiter sharing store for every algorithm the rules name.
+// Like New, it fails with ErrUnsupportedStorage if store lacks operations one of those algorithms needs.
+func NewRouter(store storage.Storage, rules *config.Rules) (*Router, error) {
+    if rules == nil {
+        return nil, config.ErrNilPolicy
+    }
+
+    limiters := make(map[string]Limiter)
+    for _, rule := range rules.Rules() {
+        if limiters[rule.Algorithm] != nil {
+            continue
+        }
+        limiter, err := New(rule.Algorithm, store, rules)
+        if err != nil {
+            return nil, fmt.Errorf("line %d: %w", rule.Line, err)
+        }
+        limiters[rule.Algorithm] = limiter
+    }
+    return &Router{rules: rules, limiters: limiters}, nil
+}
+
+// route returns the limiter enforcing the rule matching key.
+func (r *Router) route(key string) (Limiter, error) {
+    rule, ok := r.rules.Match(key)
+    if !ok {
+        return nil, fmt.Errorf("%w %q", config.ErrNoLimits, key)
+    }
+    return r.limiters[rule.Algorithm], nil
+}
+
+// Allow checks if a request is allowed for a given key.
+func (r *Router) Allow(ctx context.Context, key string) (bool, error) {
+    return r.AllowN(ctx, key, 1)
+}
+
+// AllowN checks if a request costing n units is allowed for a given key.
+func (r *Router) AllowN(ctx context.Context, key string, n int) (bool, error) {
+    limiter, err := r.route(key)
+    if err != nil {
+        return false, err
+    }
+    return limiter.AllowN(ctx, key, n)
+}
+
+// AllowResult is like AllowN but reports the full outcome of the decision.
+func (r *Router) AllowResult(ctx context.Context, key string, n int) (Result, error) {
+    limiter, err := r.route(key)
+    if err != nil {
+        return Result{}, err
+    }
+    return limiter.AllowResult(ctx, key, n)
+}
+
+// Check reports the result AllowResult would return for a request costing n units, without consuming anything.
+func (r *Router) Check(ctx context.Context, key string, n int) (Result, error) {
+    limiter, err := r.route(key)
+    if err != nil {
+        return Result{}, err
+    }
+    return limiter.Check(ctx, key, n)
+}
+
+// Wait blocks until a request is allowed for a given key, or ctx is done.
+func (r *Router) Wait(ctx context.Context, key string) error {
+    return r.WaitN(ctx, key, 1)
+}
+
+// WaitN blocks until a request costing n units is allowed for a given key, or ctx is done.
+func (r *Router) WaitN(ctx context.Context, key string, n int) error {
+    limiter, err := r.route(key)
+    if err != nil {
+        return err
+    }
+    return limiter.WaitN(ctx, key, n)
+}
+
+// Reserve reserves n units for a given key.
+func (r *Router) Reserve(ctx context.Context, key string, n int) (*Reservation, error) {
+    limiter, err := r.route(key)
+    if err != nil {
+        return nil, err
+    }
+    return limiter.Reserve(ctx, key, n)
+}
+
+// Refund credits back n units previously consumed for a given key.
+func (r *Router) Refund(ctx context.Context, key string, n int) error {
+    limiter, err := r.route(key)
+    if err != nil {
+        return err
+    }
+    return limiter.Refund(ctx, key, n)
+}
+
+// Charge consumes n units for a given key after the fact, even if that exceeds the limit.
+func (r *Router) Charge(ctx context.Context, key string, n int) error {
+    limiter, err := r.route(key)
+    if err != nil {
+        return err
+    }
+    return limiter.Charge(ctx, key, n)
+}
+
+// Reset clears the usage recorded for a given key.
+func (r *Router) Reset(ctx context.Context, key string) error {
+    limiter, err := r.route(key)
+    if err != nil {
+        return err
+    }
+    return limiter.Reset(ctx, key)
+}
+
+// Grant raises the limit of a given key by n units for the given duration.
+func (r *Router) Grant(ctx context.Context, key string, n int, ttl time.Duration) error {
+    limiter, err := r.route(key)
+    if err != nil {
+        return err
+    }
+    return limiter.Grant(ctx, key, n, ttl)
+}
+
+// Revoke withdraws any units granted to a given key before the grant expires.
+func (r *Router) Revoke(ctx context.Context, key string) error {
+    limiter, err := r.route(key)
+    if err != nil {
+        return err
+    }
+    return limiter.Revoke(ctx, key)
+}
+
+// Quota returns the current quota information of a given key.
+func (r *Router) Quota(ctx context.Context, key string) (Quota, error) {
+    limiter, err := r.route(key)
+    if err != nil {
+        return Quota{}, err
+    }
+    return limiter.Quota(ctx, key)
+}
+
+// NextAllowed returns the time duration until the next allowed request for a given key.
+func (r *Router) NextAllowed(ctx context.Context, key string) (time.Duration, error) {
+    limiter, err := r.route(key)
+    if err != nil {
+        return 0, err
+    }
+    return limiter.NextAllowed(ctx, key)
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+    "context"
+    "errors"
+    "testing"
+
+    "github.com/umbeluzi/ratelimit/config"
+    "github.com/umbeluzi/ratelimit/storage"
+)
+
+const testRules = `
+rules:
+  - key: "search:*"
+    algorithm: gcra
+    limit: 100
+    interval: 1m
+  - key: "login:*"
+    algorithm: slidinglog
+    limit: 5
+    interval: 15m
+`
+
+func TestRouter(t *testing.T) {
+    rules, err := config.ParseRules([]byte(testRules))
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    store := storage.NewInMemoryStorage()
+    defer store.Close()
+
+    router, err := NewRouter(store, rules)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    ctx := context.Background()
+
+    // Each key is limited by the algorithm and limits of its rule
+    tests := []struct {
+        key       string
+        algorithm string
+        limit     int
+    }{
+        {"search:alice", GCRA, 100},
+        {"login:alice", SlidingLog, 5},
+    }
+    for _, tt := range tests {
+        if allowed, _ := router.AllowN(ctx, tt.key, tt.limit); !allowed {
+            t.Errorf("%s: expected the whole limit to be allowed", tt.key)
+        }
+        if allowed, _ := router.Allow(ctx, tt.key); allowed {
+            t.Errorf("%s: expected no more than the limit to be allowed", tt.key)
+        }
+        quota, err := router.Quota(ctx, tt.key)
+        if err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+        if quota.Algorithm != tt.algorithm || quota.Limit != tt.limit {
+            t.Errorf("%s: expected %s with a limit of %d, got %+v", tt.key, tt.algorithm, tt.limit, quota)
+        }
+    }
+
+    if _, err := router.Allow(ctx, "upload:alice"); !errors.Is(err, config.ErrNoLimits) {
+        t.Errorf("expected ErrNoLimits for a key matching no rule, got %v", err)
+    }
+}
+
+func TestNewRouter_UnsupportedStorage(t *testing.T) {
+    rules, err := config.ParseRules([]byte(testRules))
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    store := struct{ storage.Storage }{storage.NewInMemoryStorage()}
+    if _, err := NewRouter(store, rules); !errors.Is(err, ErrUnsupportedStorage) {
+        t.Errorf("expected ErrUnsupportedStorage, got %v", err)
+    }
+
+    if _, err := NewRouter(store, nil); !errors.Is(err, config.ErrNilPolicy) {
+        t.Errorf("expected ErrNilPolicy, got %v", err)
+    }
+}
@@ -0,0 +1,405 @@
+package slidinglog
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "math"
+    "strconv"
+    "time"
+
+    "github.com/umbeluzi/ratelimit/config"
+    "github.com/umbeluzi/ratelimit/limiter"
+    "github.com/umbeluzi/ratelimit/storage"
+)
+
+// Name is the name of the sliding window log algorithm.
+const Name = "slidinglog"
+
+// ErrInvalidInterval is returned when the configured interval is not positive.
+var ErrInvalidInterval = errors.New("slidinglog: interval must be positive")
+
+// SlidingLog is an implementation of the sliding window log rate limiting algorithm.
+// The timestamp of every request is recorded in a sorted set, entries older than the interval are dropped,
+// and a request is allowed if the exact number of requests in the last interval stays within the limit.
+// It trades memory, one entry per request, for exact accounting.
+type SlidingLog struct {
+    storage storage.SortedSetStorage
+    policy  config.Policy
+    now     func() time.Time
+}
+
+// New creates a new SlidingLog rate limiter.
+func New(storage storage.SortedSetStorage, policy config.Policy) *SlidingLog {
+    return &SlidingLog{
+        storage: storage,
+        policy:  policy,
+        now:     time.Now,
+    }
+}
+
+// score converts a time to the score under which requests are logged, in Unix microseconds.
+func score(t time.Time) int64 {
+    return t.UnixMicro()
+}
+
+// members returns n unique member names for requests logged at the given score.
+func members(at int64, n int) ([]string, error) {
+    var nonce [8]byte
+    if _, err := rand.Read(nonce[:]); err != nil {
+        return nil, err
+    }
+    prefix := strconv.FormatInt(at, 10) + ":" + hex.EncodeToString(nonce[:]) + ":"
+    names := make([]string, n)
+    for i := range names {
+        names[i] = prefix + strconv.Itoa(i)
+    }
+    return names, nil
+}
+
+// limits reads the limit and interval that apply to key.
+func (sl *SlidingLog) limits(ctx context.Context, key string) (maxRequests, burstLimit int, interval time.Duration, err error) {
+    limits, err := config.LimitsFor(ctx, sl.policy, key)
+    if err != nil {
+        return 0, 0, 0, err
+    }
+    if limits.Period <= 0 {
+        return 0, 0, 0, ErrInvalidInterval
+    }
+    return limits.Requests, limits.Burst, limits.Period, nil
+}
+
+// retryAfter returns how long until excess logged requests have left the window, making room for a new request
+// given the number of requests currently logged. It returns zero if there is already room.
+func (sl *SlidingLog) retryAfter(ctx context.Context, key string, now time.Time, count, excess int, interval time.Duration) (time.Duration, error) {
+    if excess <= 0 {
+        return 0, nil
+    }
+    if excess > count {
+        // The request does not fit even into an empty window
+        return interval, nil
+    }
+
+    scores, err := sl.storage.Scores(ctx, key, score(now.Add(-interval))+1, math.MaxInt64, excess)
+    if err != nil {
+        return 0, err
+    }
+    if len(scores) < excess {
+        return 0, nil
+    }
+
+    expiresAt := time.UnixMicro(scores[excess-1]).Add(interval)
+    if wait := expiresAt.Sub(now); wait > 0 {
+        return wait, nil
+    }
+    return 0, nil
+}
+
+// Allow checks if a request is allowed for a given key using the sliding window log algorithm.
+func (sl *SlidingLog) Allow(ctx context.Context, key string) (bool, error) {
+    return sl.AllowN(ctx, key, 1)
+}
+
+// AllowN checks if a request costing n units is allowed for a given key using the sliding window log algorithm.
+// If the request would exceed the remaining quota, it is denied and nothing is consumed.
+func (sl *SlidingLog) AllowN(ctx context.Context, key string, n int) (bool, error) {
+    result, err := sl.AllowResult(ctx, key, n)
+    if err != nil {
+        return false, err
+    }
+    return result.Allowed, nil
+}
+
+// Wait blocks until a request is allowed for a given key using the sliding window log algorithm, or ctx is done.
+func (sl *SlidingLog) Wait(ctx context.Context, key string) error {
+    return sl.WaitN(ctx, key, 1)
+}
+
+// WaitN blocks until a request costing n units is allowed for a given key using the sliding window log algorithm, or ctx is
+// done. It fails immediately if n exceeds the limit or the request would not be allowed before ctx's deadline.
+func (sl *SlidingLog) WaitN(ctx context.Context, key string, n int) error {
+    return limiter.Wait(ctx, n, func(ctx context.Context) (limiter.Result, error) {
+        return sl.AllowResult(ctx, key, n)
+    })
+}
+
+// AllowResult is like AllowN but reports the full outcome of the decision.
+// A request costing n units is logged as n entries. They are added before counting and removed again if the
+// request is denied, so concurrent callers sharing a storage can never admit more than the limit, and no lock is held
+// across the storage calls. Requests racing for the last units may all be denied. A request costing more than the
+// limit is denied without logging anything.
+// A non-positive n consumes nothing and only reports the current quota.
+func (sl *SlidingLog) AllowResult(ctx context.Context, key string, n int) (limiter.Result, error) {
+    result, _, err := sl.allow(ctx, key, n)
+    return result, err
+}
+
+// allow implements AllowResult and also returns the members logged for an allowed request.
+func (sl *SlidingLog) allow(ctx context.Context, key string, n int) (limiter.Result, []string, error) {
+    if n < 0 {
+        n = 0
+    }
+
+    maxRequests, burstLimit, interval, err := sl.limits(ctx, key)
+    if err != nil {
+        return limiter.Result{}, nil, err
+    }
+
+    granted, err := limiter.Granted(ctx, sl.storage, key)
+    if err != nil {
+        return limiter.Result{}, nil, err
+    }
+
+    limit := maxRequests + burstLimit + granted
+    now := sl.now()
+    if n > limit {
+        // The request does not fit even into an empty window, so deny it without logging n entries
+        result, err := sl.check(ctx, key, n, limit, interval, now)
+        return result, nil, err
+    }
+    floor := score(now.Add(-interval))
+
+    if _, err := sl.storage.RemoveMembersByScore(ctx, key, math.MinInt64, floor); err != nil {
+        return limiter.Result{}, nil, err
+    }
+
+    logged, err := members(score(now), n)
+    if err != nil {
+        return limiter.Result{}, nil, err
+    }
+    if err := sl.storage.AddMembers(ctx, key, score(now), interval, logged...); err != nil {
+        return limiter.Result{}, nil, err
+    }
+
+    count, err := sl.storage.CountMembers(ctx, key, floor+1, math.MaxInt64)
+    if err != nil {
+        return limiter.Result{}, nil, err
+    }
+
+    allowed := count <= limit
+    if !allowed {
+        if err := sl.storage.RemoveMembers(ctx, key, logged...); err != nil {
+            return limiter.Result{}, nil, err
+        }
+        count -= n
+    }
+
+    ttl, err := sl.storage.TTL(ctx, key)
+    if err != nil {
+        return limiter.Result{}, nil, err
+    }
+
+    remaining := limit - count
+    if remaining < 0 {
+        remaining = 0
+    }
+
+    result := limiter.Result{
+        Allowed:   allowed,
+        Limit:     limit,
+        Remaining: remaining,
+        ResetAt:   now.Add(ttl),
+    }
+    if !allowed {
+        result.RetryAfter, err = sl.retryAfter(ctx, key, now, count, count+n-limit, interval)
+        if err != nil {
+            return limiter.Result{}, nil, err
+        }
+        return result, nil, nil
+    }
+
+    return result, logged, nil
+}
+
+// Check reports the result AllowResult would return for a request costing n units, without logging anything.
+// It only counts the entries within the window.
+func (sl *SlidingLog) Check(ctx context.Context, key string, n int) (limiter.Result, error) {
+    if n < 0 {
+        n = 0
+    }
+
+    maxRequests, burstLimit, interval, err := sl.limits(ctx, key)
+    if err != nil {
+        return limiter.Result{}, err
+    }
+
+    granted, err := limiter.Granted(ctx, sl.storage, key)
+    if err != nil {
+        return limiter.Result{}, err
+    }
+
+    return sl.check(ctx, key, n, maxRequests+burstLimit+granted, interval, sl.now())
+}
+
+// check implements Check for a key with the given limit and interval, as of now.
+func (sl *SlidingLog) check(ctx context.Context, key string, n, limit int, interval time.Duration, now time.Time) (limiter.Result, error) {
+    count, err := sl.storage.CountMembers(ctx, key, score(now.Add(-interval))+1, math.MaxInt64)
+    if err != nil {
+        return limiter.Result{}, err
+    }
+
+    ttl, err := sl.storage.TTL(ctx, key)
+    if err != nil {
+        return limiter.Result{}, err
+    }
+
+    allowed := count+n <= limit
+    if allowed && n > 0 {
+        count += n
+        // Logging the request would extend the log's lifetime to a full interval
+        ttl = interval
+    }
+
+    remaining := limit - count
+    if remaining < 0 {
+        remaining = 0
+    }
+
+    result := limiter.Result{
+        Allowed:   allowed,
+        Limit:     limit,
+        Remaining: remaining,
+        ResetAt:   now.Add(ttl),
+    }
+    if !allowed {
+        result.RetryAfter, err = sl.retryAfter(ctx, key, now, count, count+n-limit, interval)
+        if err != nil {
+            return limiter.Result{}, err
+        }
+    }
+
+    return result, nil
+}
+
+// Reserve reserves n units for a given key. A sliding window log can only reserve units available now, so the
+// reservation is OK if the request is allowed now and never has a delay; otherwise its Delay reports when the
+// request would fit. Canceling it removes the request's entries from the log.
+func (sl *SlidingLog) Reserve(ctx context.Context, key string, n int) (*limiter.Reservation, error) {
+    result, logged, err := sl.allow(ctx, key, n)
+    if err != nil {
+        return nil, err
+    }
+
+    now := sl.now()
+    if !result.Allowed {
+        return limiter.NewReservation(false, now.Add(result.RetryAfter), nil), nil
+    }
+    return limiter.NewReservation(true, now, func(ctx context.Context) error {
+        return sl.storage.RemoveMembers(ctx, key, logged...)
+    }), nil
+}
+
+// Refund credits back n units previously consumed for a given key, for requests that should not count against
+// the limit after all. The n most recent entries are removed from the log.
+func (sl *SlidingLog) Refund(ctx context.Context, key string, n int) error {
+    if n <= 0 {
+        return nil
+    }
+    _, err := sl.storage.RemoveLastMembers(ctx, key, n)
+    return err
+}
+
+// Charge consumes n units for a given key after the fact, even if that exceeds the limit, for costs that are
+// only known once a request has been served. n entries are logged at the current time, but never more than the
+// limit: that many entries already deny the key until they leave the window, and more would only cost memory.
+func (sl *SlidingLog) Charge(ctx context.Context, key string, n int) error {
+    if n <= 0 {
+        return nil
+    }
+
+    maxRequests, burstLimit, interval, err := sl.limits(ctx, key)
+    if err != nil {
+        return err
+    }
+
+    granted, err := limiter.Granted(ctx, sl.storage, key)
+    if err != nil {
+        return err
+    }
+    if limit := maxRequests + burstLimit + granted; n > limit {
+        n = limit
+    }
+    if n <= 0 {
+        return nil
+    }
+
+    at := score(sl.now())
+    logged, err := members(at, n)
+    if err != nil {
+        return err
+    }
+    return sl.storage.AddMembers(ctx, key, at, interval, logged...)
+}
+
+// Reset clears the usage recorded for a given key, giving it its full quota back. Quota granted to the key is kept.
+func (sl *SlidingLog) Reset(ctx context.Context, key string) error {
+    return sl.storage.Reset(ctx, key)
+}
+
+// Grant raises the limit of a given key by n units for the given duration, on top of the configured limit and burst.
+// Granting again adds to the current grant and restarts its expiry. See limiter.Grant.
+func (sl *SlidingLog) Grant(ctx context.Context, key string, n int, ttl time.Duration) error {
+    return limiter.Grant(ctx, sl.storage, key, n, ttl)
+}
+
+// Revoke withdraws any units granted to a given key before the grant expires.
+func (sl *SlidingLog) Revoke(ctx context.Context, key string) error {
+    return limiter.Revoke(ctx, sl.storage, key)
+}
+
+// Quota returns the current quota information. The window is the interval ending now.
+func (sl *SlidingLog) Quota(ctx context.Context, key string) (limiter.Quota, error) {
+    maxRequests, burstLimit, interval, err := sl.limits(ctx, key)
+    if err != nil {
+        return limiter.Quota{}, err
+    }
+
+    now := sl.now()
+    count, err := sl.storage.CountMembers(ctx, key, score(now.Add(-interval))+1, math.MaxInt64)
+    if err != nil {
+        return limiter.Quota{}, err
+    }
+
+    granted, err := limiter.Granted(ctx, sl.storage, key)
+    if err != nil {
+        return limiter.Quota{}, err
+    }
+
+    remaining := maxRequests + burstLimit + granted - count
+    if remaining < 0 {
+        remaining = 0
+    }
+
+    return limiter.Quota{
+        Algorithm:   Name,
+        Used:        count,
+        Limit:       maxRequests,
+        Burst:       burstLimit,
+        Granted:     granted,
+        Remaining:   remaining,
+        WindowStart: now.Add(-interval),
+        WindowEnd:   now,
+    }, nil
+}
+
+// NextAllowed returns the time duration until the next allowed request.
+func (sl *SlidingLog) NextAllowed(ctx context.Context, key string) (time.Duration, error) {
+    maxRequests, burstLimit, interval, err := sl.limits(ctx, key)
+    if err != nil {
+        return 0, err
+    }
+
+    now := sl.now()
+    count, err := sl.storage.CountMembers(ctx, key, score(now.Add(-interval))+1, math.MaxInt64)
+    if err != nil {
+        return 0, err
+    }
+
+    granted, err := limiter.Granted(ctx, sl.storage, key)
+    if err != nil {
+        return 0, err
+    }
+
+    return sl.retryAfter(ctx, key, now, count, count+1-(maxRequests+burstLimit+granted), interval)
+}
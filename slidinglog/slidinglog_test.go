@@ -0,0 +1,241 @@
+package slidinglog
+
+import (
+    "context"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/umbeluzi/ratelimit/config"
+    "github.com/umbeluzi/ratelimit/internal/clocktest"
+    "github.com/umbeluzi/ratelimit/storage"
+)
+
+func newTestLimiter(t *testing.T) *SlidingLog {
+    sl := New(clocktest.Storage(t), clocktest.Policy())
+    clocktest.Pin(&sl.now, 0)
+    return sl
+}
+
+func TestSlidingLog_Allow(t *testing.T) {
+    sl := newTestLimiter(t)
+
+    for i := 0; i < 9; i++ {
+        allowed, err := sl.Allow(context.Background(), "test")
+        if err != nil {
+            t.Errorf("unexpected error: %v", err)
+        }
+
+        if i < 7 && !allowed {
+            t.Errorf("request %d should be allowed", i+1)
+        }
+
+        if i >= 7 && allowed {
+            t.Errorf("request %d should be denied", i+1)
+        }
+    }
+}
+
+func TestSlidingLog_AllowN(t *testing.T) {
+    sl := newTestLimiter(t)
+
+    steps := []struct {
+        n       int
+        allowed bool
+    }{
+        {5, true},
+        {3, false},
+        {2, true},
+        {1, false},
+    }
+
+    for i, step := range steps {
+        allowed, err := sl.AllowN(context.Background(), "test", step.n)
+        if err != nil {
+            t.Errorf("unexpected error: %v", err)
+        }
+
+        if allowed != step.allowed {
+            t.Errorf("step %d: expected allowed=%v for n=%d, got %v", i+1, step.allowed, step.n, allowed)
+        }
+    }
+
+    quota, _ := sl.Quota(context.Background(), "test")
+    if quota.Used != 7 {
+        t.Errorf("expected denied requests not to consume quota, got %d used", quota.Used)
+    }
+}
+
+func TestSlidingLog_ExactWindow(t *testing.T) {
+    sl := newTestLimiter(t)
+    ctx := context.Background()
+
+    // Spread the quota over the first half of the interval
+    for i := 0; i < 7; i++ {
+        clocktest.Pin(&sl.now, time.Duration(i)*5*time.Second)
+        if allowed, _ := sl.Allow(ctx, "test"); !allowed {
+            t.Fatalf("request %d should be allowed", i+1)
+        }
+    }
+
+    // The first request leaves the window exactly one interval after it was made
+    clocktest.Pin(&sl.now, time.Minute-time.Microsecond)
+    result, err := sl.AllowResult(ctx, "test", 1)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if result.Allowed || result.RetryAfter != time.Microsecond {
+        t.Errorf("expected denial with RetryAfter of 1µs, got %+v", result)
+    }
+
+    clocktest.Pin(&sl.now, time.Minute)
+    if allowed, _ := sl.Allow(ctx, "test"); !allowed {
+        t.Errorf("request should be allowed once the oldest entry left the window")
+    }
+
+    // Two more units need the next two oldest entries, logged at 5s and 10s, to leave the window
+    result, _ = sl.AllowResult(ctx, "test", 2)
+    if result.Allowed || result.RetryAfter != 10*time.Second {
+        t.Errorf("expected denial with RetryAfter of 10s, got %+v", result)
+    }
+}
+
+func TestSlidingLog_AllowResult(t *testing.T) {
+    sl := newTestLimiter(t)
+
+    result, err := sl.AllowResult(context.Background(), "test", 5)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if !result.Allowed || result.Limit != 7 || result.Remaining != 2 || result.RetryAfter != 0 {
+        t.Errorf("unexpected result for allowed request: %+v", result)
+    }
+
+    result, err = sl.AllowResult(context.Background(), "test", 8)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if result.Allowed || result.Remaining != 2 || result.RetryAfter != time.Minute {
+        t.Errorf("unexpected result for oversized request: %+v", result)
+    }
+}
+
+func TestSlidingLog_Check(t *testing.T) {
+    sl := newTestLimiter(t)
+    ctx := context.Background()
+
+    sl.AllowN(ctx, "test", 5)
+    clocktest.Pin(&sl.now, 30*time.Second)
+    sl.AllowN(ctx, "test", 2)
+
+    // Checks only count the entries within the window, even before expired entries are removed
+    clocktest.Pin(&sl.now, 61*time.Second)
+    checked, err := sl.Check(ctx, "test", 5)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if !checked.Allowed || checked.Remaining != 0 {
+        t.Errorf("expected the entries logged at 0s to be out of the window, got %+v", checked)
+    }
+
+    // A denied check reports when enough entries leave the window
+    checked, _ = sl.Check(ctx, "test", 6)
+    if checked.Allowed || checked.RetryAfter != 29*time.Second {
+        t.Errorf("expected the entries logged at 30s to leave the window in 29s, got %+v", checked)
+    }
+}
+
+func TestSlidingLog_Quota(t *testing.T) {
+    sl := newTestLimiter(t)
+
+    for i := 0; i < 3; i++ {
+        sl.Allow(context.Background(), "test")
+    }
+
+    quota, err := sl.Quota(context.Background(), "test")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    if quota.Algorithm != Name || quota.Used != 3 || quota.Limit != 5 || quota.Burst != 2 || quota.Remaining != 4 {
+        t.Errorf("unexpected quota: %+v", quota)
+    }
+
+    if quota.WindowEnd.Sub(quota.WindowStart) != time.Minute {
+        t.Errorf("expected a one minute window, got %s to %s", quota.WindowStart, quota.WindowEnd)
+    }
+}
+
+func TestSlidingLog_InvalidInterval(t *testing.T) {
+    sl := New(clocktest.Storage(t), config.NewStatic(5, 0, 2, 0, time.Now()))
+    if _, err := sl.Allow(context.Background(), "test"); err != ErrInvalidInterval {
+        t.Errorf("expected ErrInvalidInterval, got %v", err)
+    }
+}
+
+// countingStorage counts the members logged through it.
+type countingStorage struct {
+    storage.SortedSetStorage
+    added int
+}
+
+func (s *countingStorage) AddMembers(ctx context.Context, key string, score int64, ttl time.Duration, members ...string) error {
+    s.added += len(members)
+    return s.SortedSetStorage.AddMembers(ctx, key, score, ttl, members...)
+}
+
+func TestSlidingLog_Oversized(t *testing.T) {
+    storage := &countingStorage{SortedSetStorage: clocktest.Storage(t)}
+    sl := New(storage, clocktest.Policy())
+    clocktest.Pin(&sl.now, 0)
+    ctx := context.Background()
+
+    // A request that can never fit is denied without logging its entries
+    result, err := sl.AllowResult(ctx, "test", 2_000_000)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if result.Allowed || result.Limit != 7 || result.Remaining != 7 || result.RetryAfter != time.Minute {
+        t.Errorf("unexpected result for oversized request: %+v", result)
+    }
+    if storage.added != 0 {
+        t.Errorf("expected nothing to be logged, got %d entries", storage.added)
+    }
+
+    // Charging more than the limit logs no more than the limit
+    if err := sl.Charge(ctx, "test", 2_000_000); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if storage.added != 7 {
+        t.Errorf("expected the charge to be capped at 7 entries, got %d", storage.added)
+    }
+    if allowed, _ := sl.Allow(ctx, "test"); allowed {
+        t.Error("expected the key to be exhausted after the charge")
+    }
+}
+
+func TestSlidingLog_Concurrent(t *testing.T) {
+    sl := newTestLimiter(t)
+    ctx := context.Background()
+
+    var allowed int32
+    var wg sync.WaitGroup
+    for i := 0; i < 20; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            if ok, _ := sl.Allow(ctx, "test"); ok {
+                atomic.AddInt32(&allowed, 1)
+            }
+        }()
+    }
+    wg.Wait()
+
+    if allowed > 7 {
+        t.Errorf("expected no more than 7 concurrent requests to be allowed, got %d", allowed)
+    }
+    if quota, _ := sl.Quota(ctx, "test"); quota.Used != int(allowed) {
+        t.Errorf("expected only allowed requests to be logged, got %+v for %d allowed", quota, allowed)
+    }
+}
@@ -2,93 +2,454 @@ package slidingwindow
 
 import (
     "context"
+    "errors"
+    "math"
+    "strconv"
     "sync"
     "time"
 
     "github.com/umbeluzi/ratelimit/config"
+    "github.com/umbeluzi/ratelimit/limiter"
     "github.com/umbeluzi/ratelimit/storage"
 )
 
-// SlidingWindow is an implementation of the sliding window rate limiting algorithm.
+// Name is the name of the sliding window algorithm.
+const Name = "slidingwindow"
+
+// ErrInvalidInterval is returned when the configured interval is not positive.
+var ErrInvalidInterval = errors.New("slidingwindow: interval must be positive")
+
+// SlidingWindow is an implementation of the sliding window counter rate limiting algorithm.
+// Requests are counted in fixed windows stored under per-window sub-keys, and a request is allowed if the
+// current window's count plus the previous window's count, weighted by how much of the previous window still
+// overlaps the sliding window, stays within the limit. This prevents the 2x bursts a fixed window allows at
+// window boundaries.
 type SlidingWindow struct {
     storage storage.Storage
-    config  config.Config
+    policy  config.Policy
     mu      sync.Mutex
+    now     func() time.Time
 }
 
 // New creates a new SlidingWindow rate limiter.
-func New(storage storage.Storage, config config.Config) *SlidingWindow {
+func New(storage storage.Storage, policy config.Policy) *SlidingWindow {
     return &SlidingWindow{
         storage: storage,
-        config:  config,
+        policy:  policy,
+        now:     time.Now,
+    }
+}
+
+// counts holds the counters of the current and previous windows as seen at a point in time.
+type counts struct {
+    start    time.Time
+    elapsed  time.Duration
+    previous int
+    current  int
+}
+
+// windowKey returns the sub-key holding the counter of the window with the given index.
+func windowKey(key string, index int64) string {
+    return key + ":" + strconv.FormatInt(index, 10)
+}
+
+// window returns the index and start of the window containing now.
+func window(now time.Time, size time.Duration) (int64, time.Time) {
+    index := now.UnixNano() / int64(size)
+    return index, time.Unix(0, index*int64(size))
+}
+
+// load reads the counters of the current and previous windows for a given key.
+func (sw *SlidingWindow) load(ctx context.Context, key string, now time.Time, size time.Duration) (counts, error) {
+    index, start := window(now, size)
+
+    previous, err := sw.storage.Get(ctx, windowKey(key, index-1))
+    if err != nil {
+        return counts{}, err
+    }
+
+    current, err := sw.storage.Get(ctx, windowKey(key, index))
+    if err != nil {
+        return counts{}, err
+    }
+
+    return counts{
+        start:    start,
+        elapsed:  now.Sub(start),
+        previous: previous,
+        current:  current,
+    }, nil
+}
+
+// weighted returns the share of the previous window's count that still falls within the sliding window, rounded up.
+func (c counts) weighted(size time.Duration) int {
+    overlap := float64(size-c.elapsed) / float64(size)
+    return int(math.Ceil(float64(c.previous) * overlap))
+}
+
+// retryAfter returns how long to wait until a request costing n fits within limit, assuming no other requests.
+func (c counts) retryAfter(n, limit int, size time.Duration) time.Duration {
+    if room := limit - c.current - n; room >= 0 {
+        if c.previous <= room {
+            return 0
+        }
+        // The weighted previous count decays linearly; find when it drops to room
+        at := size - time.Duration(float64(size)*float64(room)/float64(c.previous))
+        if at < c.elapsed {
+            return 0
+        }
+        return at - c.elapsed
+    }
+
+    // The request only fits once the current window has become the previous one
+    wait := size - c.elapsed
+    room := limit - n
+    if room < 0 {
+        return wait + size
+    }
+    if c.current > room {
+        wait += size - time.Duration(float64(size)*float64(room)/float64(c.current))
+    }
+    return wait
+}
+
+// resetAt returns the time at which both windows have slid out of the sliding window.
+func (c counts) resetAt(now time.Time, size time.Duration) time.Time {
+    switch {
+    case c.current > 0:
+        return c.start.Add(2 * size)
+    case c.previous > 0:
+        return c.start.Add(size)
+    default:
+        return now
     }
 }
 
 // Allow checks if a request is allowed for a given key using the sliding window algorithm.
 func (sw *SlidingWindow) Allow(ctx context.Context, key string) (bool, error) {
+    return sw.AllowN(ctx, key, 1)
+}
+
+// AllowN checks if a request costing n units is allowed for a given key using the sliding window algorithm.
+// If the request would exceed the remaining quota, it is denied and nothing is consumed.
+func (sw *SlidingWindow) AllowN(ctx context.Context, key string, n int) (bool, error) {
+    result, err := sw.AllowResult(ctx, key, n)
+    if err != nil {
+        return false, err
+    }
+    return result.Allowed, nil
+}
+
+// Wait blocks until a request is allowed for a given key using the sliding window algorithm, or ctx is done.
+func (sw *SlidingWindow) Wait(ctx context.Context, key string) error {
+    return sw.WaitN(ctx, key, 1)
+}
+
+// WaitN blocks until a request costing n units is allowed for a given key using the sliding window algorithm, or ctx is
+// done. It fails immediately if n exceeds the limit or the request would not be allowed before ctx's deadline.
+func (sw *SlidingWindow) WaitN(ctx context.Context, key string, n int) error {
+    return limiter.Wait(ctx, n, func(ctx context.Context) (limiter.Result, error) {
+        return sw.AllowResult(ctx, key, n)
+    })
+}
+
+// AllowResult is like AllowN but reports the full outcome of the decision. The current window's counter is
+// checked and incremented in a single storage operation; the previous window is closed and only read.
+// A non-positive n consumes nothing and only reports the current quota.
+func (sw *SlidingWindow) AllowResult(ctx context.Context, key string, n int) (limiter.Result, error) {
+    result, _, err := sw.allow(ctx, key, n)
+    return result, err
+}
+
+// allow implements AllowResult and also returns the sub-key of the window charged for the request.
+func (sw *SlidingWindow) allow(ctx context.Context, key string, n int) (limiter.Result, string, error) {
+    if n < 0 {
+        n = 0
+    }
+
     sw.mu.Lock()
     defer sw.mu.Unlock()
 
-    maxRequests, err := sw.config.MaxRequests(ctx)
+    limits, err := config.LimitsFor(ctx, sw.policy, key)
     if err != nil {
-        return false, err
+        return limiter.Result{}, "", err
     }
+    maxRequests, size, burstLimit := limits.Requests, limits.Period, limits.Burst
 
-    window, err := sw.config.Interval(ctx)
+    if size <= 0 {
+        return limiter.Result{}, "", ErrInvalidInterval
+    }
+
+    now := sw.now()
+    index, start := window(now, size)
+
+    previous, err := sw.storage.Get(ctx, windowKey(key, index-1))
     if err != nil {
-        return false, err
+        return limiter.Result{}, "", err
     }
 
-    burstLimit, err := sw.config.BurstLimit(ctx)
+    c := counts{
+        start:    start,
+        elapsed:  now.Sub(start),
+        previous: previous,
+    }
+
+    granted, err := limiter.Granted(ctx, sw.storage, key)
     if err != nil {
-        return false, err
+        return limiter.Result{}, "", err
     }
 
-    count, err := sw.storage.Increment(ctx, key)
+    // Keep each window for two intervals so it can serve as the previous window
+    limit := maxRequests + burstLimit + granted
+    charged := windowKey(key, index)
+    current, _, allowed, err := storage.IncrementWithLimit(ctx, sw.storage, charged, n, limit-c.weighted(size), 2*size)
     if err != nil {
-        return false, err
+        return limiter.Result{}, "", err
+    }
+    c.current = current
+
+    remaining := limit - c.weighted(size) - c.current
+    if remaining < 0 {
+        remaining = 0
+    }
+
+    result := limiter.Result{
+        Allowed:   allowed,
+        Limit:     limit,
+        Remaining: remaining,
+        ResetAt:   c.resetAt(now, size),
+    }
+    if !allowed {
+        result.RetryAfter = c.retryAfter(n, limit, size)
+    }
+
+    return result, charged, nil
+}
+
+// Check reports the result AllowResult would return for a request costing n units, without consuming anything.
+// It only reads the window counters.
+func (sw *SlidingWindow) Check(ctx context.Context, key string, n int) (limiter.Result, error) {
+    if n < 0 {
+        n = 0
+    }
+
+    limits, err := config.LimitsFor(ctx, sw.policy, key)
+    if err != nil {
+        return limiter.Result{}, err
     }
+    maxRequests, size, burstLimit := limits.Requests, limits.Period, limits.Burst
 
-    if count == 1 {
-        // Set a TTL if this is the first request
-        err := sw.storage.SetTTL(ctx, key, window)
+    if size <= 0 {
+        return limiter.Result{}, ErrInvalidInterval
+    }
+
+    now := sw.now()
+    c, err := sw.load(ctx, key, now, size)
+    if err != nil {
+        return limiter.Result{}, err
+    }
+
+    granted, err := limiter.Granted(ctx, sw.storage, key)
+    if err != nil {
+        return limiter.Result{}, err
+    }
+
+    limit := maxRequests + burstLimit + granted
+    allowed := c.current+n <= limit-c.weighted(size)
+    if allowed {
+        c.current += n
+    }
+
+    remaining := limit - c.weighted(size) - c.current
+    if remaining < 0 {
+        remaining = 0
+    }
+
+    result := limiter.Result{
+        Allowed:   allowed,
+        Limit:     limit,
+        Remaining: remaining,
+        ResetAt:   c.resetAt(now, size),
+    }
+    if !allowed {
+        result.RetryAfter = c.retryAfter(n, limit, size)
+    }
+
+    return result, nil
+}
+
+// Reserve reserves n units for a given key. A sliding window can only reserve units available now, so the
+// reservation is OK if the request is allowed now and never has a delay; otherwise its Delay reports when the
+// request would fit. Canceling it returns the units to the window they were counted in.
+func (sw *SlidingWindow) Reserve(ctx context.Context, key string, n int) (*limiter.Reservation, error) {
+    result, charged, err := sw.allow(ctx, key, n)
+    if err != nil {
+        return nil, err
+    }
+
+    now := sw.now()
+    if !result.Allowed {
+        return limiter.NewReservation(false, now.Add(result.RetryAfter), nil), nil
+    }
+    return limiter.NewReservation(true, now, func(ctx context.Context) error {
+        _, err := sw.storage.DecrementBy(ctx, charged, n)
+        return err
+    }), nil
+}
+
+// Refund credits back n units previously consumed for a given key, for requests that should not count against
+// the limit after all. Units are credited to the current window first and then to the previous one, never below zero.
+func (sw *SlidingWindow) Refund(ctx context.Context, key string, n int) error {
+    if n <= 0 {
+        return nil
+    }
+
+    limits, err := config.LimitsFor(ctx, sw.policy, key)
+    if err != nil {
+        return err
+    }
+    size := limits.Period
+    if size <= 0 {
+        return ErrInvalidInterval
+    }
+
+    index, _ := window(sw.now(), size)
+    for _, w := range []string{windowKey(key, index), windowKey(key, index-1)} {
+        count, err := sw.storage.Get(ctx, w)
         if err != nil {
-            return false, err
+            return err
+        }
+        if count <= 0 {
+            continue
+        }
+        if count > n {
+            count = n
+        }
+        if _, err := sw.storage.DecrementBy(ctx, w, count); err != nil {
+            return err
+        }
+        if n -= count; n == 0 {
+            break
         }
     }
+    return nil
+}
+
+// Charge consumes n units for a given key after the fact, even if that exceeds the limit, for costs that are
+// only known once a request has been served. The units are counted in the current window.
+func (sw *SlidingWindow) Charge(ctx context.Context, key string, n int) error {
+    if n <= 0 {
+        return nil
+    }
+
+    limits, err := config.LimitsFor(ctx, sw.policy, key)
+    if err != nil {
+        return err
+    }
+    size := limits.Period
+    if size <= 0 {
+        return ErrInvalidInterval
+    }
+
+    index, _ := window(sw.now(), size)
+    _, _, _, err = storage.IncrementWithLimit(ctx, sw.storage, windowKey(key, index), n, math.MaxInt, 2*size)
+    return err
+}
+
+// Reset clears the usage recorded for a given key in the current and previous windows, giving it its full quota
+// back. Quota granted to the key is kept.
+func (sw *SlidingWindow) Reset(ctx context.Context, key string) error {
+    limits, err := config.LimitsFor(ctx, sw.policy, key)
+    if err != nil {
+        return err
+    }
+    size := limits.Period
+    if size <= 0 {
+        return ErrInvalidInterval
+    }
 
-    if count > maxRequests+burstLimit {
-        return false, nil
+    index, _ := window(sw.now(), size)
+    for _, i := range []int64{index - 1, index} {
+        if err := sw.storage.Reset(ctx, windowKey(key, i)); err != nil {
+            return err
+        }
     }
+    return nil
+}
+
+// Grant raises the limit of a given key by n units for the given duration, on top of the configured limit and burst.
+// Granting again adds to the current grant and restarts its expiry. See limiter.Grant.
+func (sw *SlidingWindow) Grant(ctx context.Context, key string, n int, ttl time.Duration) error {
+    return limiter.Grant(ctx, sw.storage, key, n, ttl)
+}
 
-    return true, nil
+// Revoke withdraws any units granted to a given key before the grant expires.
+func (sw *SlidingWindow) Revoke(ctx context.Context, key string) error {
+    return limiter.Revoke(ctx, sw.storage, key)
 }
 
-// Quota returns the current quota information.
-func (sw *SlidingWindow) Quota(ctx context.Context, key string) (int, int, int, error) {
-    count, err := sw.storage.Get(ctx, key)
+// Quota returns the current quota information. Used is the weighted count over the sliding window ending now.
+func (sw *SlidingWindow) Quota(ctx context.Context, key string) (limiter.Quota, error) {
+    limits, err := config.LimitsFor(ctx, sw.policy, key)
     if err != nil {
-        return 0, 0, 0, err
+        return limiter.Quota{}, err
+    }
+    maxRequests, size, burstLimit := limits.Requests, limits.Period, limits.Burst
+
+    if size <= 0 {
+        return limiter.Quota{}, ErrInvalidInterval
     }
 
-    maxRequests, err := sw.config.MaxRequests(ctx)
+    now := sw.now()
+    c, err := sw.load(ctx, key, now, size)
     if err != nil {
-        return 0, 0, 0, err
+        return limiter.Quota{}, err
     }
 
-    burstLimit, err := sw.config.BurstLimit(ctx)
+    granted, err := limiter.Granted(ctx, sw.storage, key)
     if err != nil {
-        return 0, 0, 0, err
+        return limiter.Quota{}, err
+    }
+
+    used := c.weighted(size) + c.current
+    remaining := maxRequests + burstLimit + granted - used
+    if remaining < 0 {
+        remaining = 0
     }
 
-    return count, maxRequests, burstLimit, nil
+    return limiter.Quota{
+        Algorithm:   Name,
+        Used:        used,
+        Limit:       maxRequests,
+        Burst:       burstLimit,
+        Granted:     granted,
+        Remaining:   remaining,
+        WindowStart: now.Add(-size),
+        WindowEnd:   now,
+    }, nil
 }
 
 // NextAllowed returns the time duration until the next allowed request.
 func (sw *SlidingWindow) NextAllowed(ctx context.Context, key string) (time.Duration, error) {
-    ttl, err := sw.storage.TTL(ctx, key)
+    limits, err := config.LimitsFor(ctx, sw.policy, key)
+    if err != nil {
+        return 0, err
+    }
+    maxRequests, size, burstLimit := limits.Requests, limits.Period, limits.Burst
+
+    if size <= 0 {
+        return 0, ErrInvalidInterval
+    }
+
+    c, err := sw.load(ctx, key, sw.now(), size)
+    if err != nil {
+        return 0, err
+    }
+
+    granted, err := limiter.Granted(ctx, sw.storage, key)
     if err != nil {
         return 0, err
     }
-    return ttl, nil
+
+    return c.retryAfter(1, maxRequests+burstLimit+granted, size), nil
 }
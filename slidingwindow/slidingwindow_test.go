@@ -6,20 +6,41 @@ import (
     "time"
 
     "github.com/umbeluzi/ratelimit/config"
+    "github.com/umbeluzi/ratelimit/internal/clocktest"
     "github.com/umbeluzi/ratelimit/storage"
 )
 
+var _ storage.Storage = (*MockStorage)(nil)
+
 type MockStorage struct {
-    count int
+    counts map[string]int
 }
 
 func (ms *MockStorage) Increment(ctx context.Context, key string) (int, error) {
-    ms.count++
-    return ms.count, nil
+    return ms.IncrementBy(ctx, key, 1)
+}
+
+func (ms *MockStorage) IncrementBy(ctx context.Context, key string, n int) (int, error) {
+    if ms.counts == nil {
+        ms.counts = make(map[string]int)
+    }
+    ms.counts[key] += n
+    return ms.counts[key], nil
+}
+
+func (ms *MockStorage) DecrementBy(ctx context.Context, key string, n int) (int, error) {
+    if _, ok := ms.counts[key]; !ok {
+        return 0, nil
+    }
+    ms.counts[key] -= n
+    if ms.counts[key] < 0 {
+        ms.counts[key] = 0
+    }
+    return ms.counts[key], nil
 }
 
 func (ms *MockStorage) Reset(ctx context.Context, key string) error {
-    ms.count = 0
+    delete(ms.counts, key)
     return nil
 }
 
@@ -32,7 +53,7 @@ func (ms *MockStorage) SetTTL(ctx context.Context, key string, ttl time.Duration
 }
 
 func (ms *MockStorage) Get(ctx context.Context, key string) (int, error) {
-    return ms.count, nil
+    return ms.counts[key], nil
 }
 
 func TestSlidingWindow_Allow(t *testing.T) {
@@ -40,19 +61,173 @@ func TestSlidingWindow_Allow(t *testing.T) {
     config := config.NewStatic(5, time.Minute, 2, 0, time.Now())
 
     sw := New(storage, config)
+    clocktest.Pin(&sw.now, 30*time.Second)
 
-    for i := 0; i < 7; i++ {
+    for i := 0; i < 9; i++ {
         allowed, err := sw.Allow(context.Background(), "test")
         if err != nil {
             t.Errorf("unexpected error: %v", err)
         }
 
-        if i < 5 && !allowed {
+        if i < 7 && !allowed {
             t.Errorf("request %d should be allowed", i+1)
         }
 
-        if i >= 5 && allowed {
+        if i >= 7 && allowed {
             t.Errorf("request %d should be denied", i+1)
         }
     }
 }
+
+func TestSlidingWindow_AllowN(t *testing.T) {
+    storage := &MockStorage{}
+    config := config.NewStatic(5, time.Minute, 2, 0, time.Now())
+
+    sw := New(storage, config)
+    clocktest.Pin(&sw.now, 30*time.Second)
+
+    steps := []struct {
+        n       int
+        allowed bool
+    }{
+        {5, true},
+        {3, false},
+        {2, true},
+        {1, false},
+    }
+
+    for i, step := range steps {
+        allowed, err := sw.AllowN(context.Background(), "test", step.n)
+        if err != nil {
+            t.Errorf("unexpected error: %v", err)
+        }
+
+        if allowed != step.allowed {
+            t.Errorf("step %d: expected allowed=%v for n=%d, got %v", i+1, step.allowed, step.n, allowed)
+        }
+    }
+
+    quota, _ := sw.Quota(context.Background(), "test")
+    if quota.Used != 7 {
+        t.Errorf("expected denied requests not to consume quota, got %d used", quota.Used)
+    }
+}
+
+func TestSlidingWindow_AllowResult(t *testing.T) {
+    storage := &MockStorage{}
+    config := config.NewStatic(5, time.Minute, 2, 0, time.Now())
+
+    sw := New(storage, config)
+    clocktest.Pin(&sw.now, 30*time.Second)
+
+    result, err := sw.AllowResult(context.Background(), "test", 5)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if !result.Allowed || result.Limit != 7 || result.Remaining != 2 || result.RetryAfter != 0 {
+        t.Errorf("unexpected result for allowed request: %+v", result)
+    }
+    if !result.ResetAt.Equal(clocktest.Start.Add(2 * time.Minute)) {
+        t.Errorf("expected ResetAt once the current window has slid out, got %s", result.ResetAt)
+    }
+
+    result, err = sw.AllowResult(context.Background(), "test", 3)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if result.Allowed || result.Remaining != 2 {
+        t.Errorf("unexpected result for denied request: %+v", result)
+    }
+    // The next window starts in 30s, after which 5 weighted requests must decay to 4
+    if result.RetryAfter != 42*time.Second {
+        t.Errorf("expected RetryAfter of 42s, got %s", result.RetryAfter)
+    }
+}
+
+func TestSlidingWindow_Quota(t *testing.T) {
+    storage := &MockStorage{}
+    config := config.NewStatic(5, time.Minute, 2, 0, time.Now())
+
+    sw := New(storage, config)
+    clocktest.Pin(&sw.now, 30*time.Second)
+
+    for i := 0; i < 3; i++ {
+        sw.Allow(context.Background(), "test")
+    }
+
+    quota, err := sw.Quota(context.Background(), "test")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    if quota.Algorithm != Name || quota.Used != 3 || quota.Limit != 5 || quota.Burst != 2 || quota.Remaining != 4 {
+        t.Errorf("unexpected quota: %+v", quota)
+    }
+
+    if quota.WindowEnd.Sub(quota.WindowStart) != time.Minute {
+        t.Errorf("expected a one minute window, got %s to %s", quota.WindowStart, quota.WindowEnd)
+    }
+}
+
+func TestSlidingWindow_BoundaryBurst(t *testing.T) {
+    storage := storage.NewInMemoryStorage()
+    defer storage.Close()
+    config := config.NewStatic(5, time.Minute, 2, 0, time.Now())
+
+    sw := New(storage, config)
+    ctx := context.Background()
+
+    // Use the whole quota at the very end of a window
+    clocktest.Pin(&sw.now, 59*time.Second)
+    for i := 0; i < 7; i++ {
+        allowed, err := sw.Allow(ctx, "test")
+        if err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+        if !allowed {
+            t.Errorf("request %d should be allowed", i+1)
+        }
+    }
+
+    // A fixed window would allow another 7 requests right after the boundary
+    clocktest.Pin(&sw.now, 60*time.Second+time.Millisecond)
+    allowed, err := sw.Allow(ctx, "test")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if allowed {
+        t.Errorf("request right after the window boundary should be denied")
+    }
+
+    // Halfway through the next window, half of the previous window still counts
+    clocktest.Pin(&sw.now, 90*time.Second)
+    for i := 0; i < 4; i++ {
+        allowed, _ := sw.Allow(ctx, "test")
+        if want := i < 3; allowed != want {
+            t.Errorf("request %d: expected allowed=%v, got %v", i+1, want, allowed)
+        }
+    }
+
+    // Waiting for the reported retry-after is enough for the next request
+    result, err := sw.AllowResult(ctx, "test", 1)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if result.Allowed || result.RetryAfter <= 0 {
+        t.Fatalf("expected a denied request with a retry-after, got %+v", result)
+    }
+
+    next, err := sw.NextAllowed(ctx, "test")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if next != result.RetryAfter {
+        t.Errorf("expected NextAllowed %s to match RetryAfter %s", next, result.RetryAfter)
+    }
+
+    clocktest.Pin(&sw.now, 90*time.Second+result.RetryAfter)
+    allowed, _ = sw.Allow(ctx, "test")
+    if !allowed {
+        t.Errorf("request after the retry-after should be allowed")
+    }
+}
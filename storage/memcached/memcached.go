@@ -0,0 +1,346 @@
+package memcached
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/bradfitz/gomemcache/memcache"
+    "github.com/umbeluzi/ratelimit/storage"
+)
+
+var (
+    _ storage.Storage             = (*Storage)(nil)
+    _ storage.AtomicIncrementer   = (*Storage)(nil)
+    _ storage.LimitedIncrementer  = (*Storage)(nil)
+    _ storage.RenewingIncrementer = (*Storage)(nil)
+    _ storage.StateStorage        = (*Storage)(nil)
+)
+
+// maxRetries is the number of times an update is retried after losing a compare-and-swap race.
+const maxRetries = 16
+
+// maxRelativeExpiration is the longest expiration memcached interprets as relative; longer values are Unix timestamps.
+const maxRelativeExpiration = 30 * 24 * time.Hour
+
+// Client is the subset of *memcache.Client used by Storage.
+type Client interface {
+    Get(key string) (*memcache.Item, error)
+    Add(item *memcache.Item) error
+    CompareAndSwap(item *memcache.Item) error
+    Delete(key string) error
+}
+
+// counter is the value stored for each key: the count, its expiry deadline and any state stored with
+// storage.StateStorage. Memcached cannot report the remaining TTL of an item, so the deadline is kept alongside the count.
+type counter struct {
+    value    int
+    deadline time.Time
+    state    []byte
+}
+
+// expired reports whether the counter has expired at the given time.
+func (c counter) expired(now time.Time) bool {
+    return !c.deadline.IsZero() && !now.Before(c.deadline)
+}
+
+// encode serializes the counter as "<count>:<deadline in Unix nanoseconds>", with a zero deadline meaning no expiry,
+// followed by ":<state>" if the counter holds a state.
+func (c counter) encode() []byte {
+    var deadline int64
+    if !c.deadline.IsZero() {
+        deadline = c.deadline.UnixNano()
+    }
+    encoded := []byte(strconv.Itoa(c.value) + ":" + strconv.FormatInt(deadline, 10))
+    if len(c.state) > 0 {
+        encoded = append(append(encoded, ':'), c.state...)
+    }
+    return encoded
+}
+
+// decode parses a counter serialized by encode.
+func decode(value []byte) (counter, error) {
+    count, rest, ok := strings.Cut(string(value), ":")
+    if !ok {
+        return counter{}, fmt.Errorf("memcached: malformed counter %q", value)
+    }
+    deadline, state, _ := strings.Cut(rest, ":")
+    c := counter{}
+    if state != "" {
+        c.state = []byte(state)
+    }
+    var err error
+    c.value, err = strconv.Atoi(count)
+    if err != nil {
+        return counter{}, fmt.Errorf("memcached: malformed counter %q: %w", value, err)
+    }
+    nanos, err := strconv.ParseInt(deadline, 10, 64)
+    if err != nil {
+        return counter{}, fmt.Errorf("memcached: malformed counter %q: %w", value, err)
+    }
+    if nanos != 0 {
+        c.deadline = time.Unix(0, nanos)
+    }
+    return c, nil
+}
+
+// expiration converts a deadline to a memcached expiration, rounding up to whole seconds.
+func expiration(deadline time.Time, now time.Time) int32 {
+    if deadline.IsZero() {
+        return 0
+    }
+    ttl := deadline.Sub(now)
+    if ttl > maxRelativeExpiration {
+        return int32(deadline.Unix() + 1)
+    }
+    seconds := int32((ttl + time.Second - 1) / time.Second)
+    if seconds < 1 {
+        seconds = 1
+    }
+    return seconds
+}
+
+// itemKey returns the Memcached key for key. Memcached keys may not contain spaces or control characters, so those
+// bytes, and the % used to escape them, are written as %XX. Other keys are used as they are.
+func itemKey(key string) string {
+    var b strings.Builder
+    for i := 0; i < len(key); i++ {
+        if c := key[i]; c <= ' ' || c == 0x7f || c == '%' {
+            fmt.Fprintf(&b, "%%%02X", c)
+        } else {
+            b.WriteByte(c)
+        }
+    }
+    return b.String()
+}
+
+// Storage is a Memcached implementation of the storage.Storage interface. Keys containing spaces or control
+// characters are escaped, so any key can be stored.
+type Storage struct {
+    client Client
+}
+
+// New creates a new Storage backed by the given Memcached client, typically a *memcache.Client.
+func New(client Client) *Storage {
+    return &Storage{client: client}
+}
+
+// load returns the item and live counter stored for key. A missing or expired key yields a nil item and a zero counter.
+func (s *Storage) load(key string, now time.Time) (*memcache.Item, counter, error) {
+    item, err := s.client.Get(itemKey(key))
+    if errors.Is(err, memcache.ErrCacheMiss) {
+        return nil, counter{}, nil
+    }
+    if err != nil {
+        return nil, counter{}, err
+    }
+    c, err := decode(item.Value)
+    if err != nil {
+        return nil, counter{}, err
+    }
+    if c.expired(now) {
+        return item, counter{}, nil
+    }
+    return item, c, nil
+}
+
+// update applies fn to the counter stored for key and writes the result back, using Add for new keys and
+// CompareAndSwap for existing ones. It retries when another client modifies the key concurrently.
+// If fn returns false, nothing is written.
+func (s *Storage) update(ctx context.Context, key string, fn func(c *counter, now time.Time) bool) (counter, error) {
+    for i := 0; i < maxRetries; i++ {
+        if err := ctx.Err(); err != nil {
+            return counter{}, err
+        }
+
+        now := time.Now()
+        item, c, err := s.load(key, now)
+        if err != nil {
+            return counter{}, err
+        }
+        if !fn(&c, now) {
+            return c, nil
+        }
+
+        if item == nil {
+            err = s.client.Add(&memcache.Item{Key: itemKey(key), Value: c.encode(), Expiration: expiration(c.deadline, now)})
+        } else {
+            item.Value = c.encode()
+            item.Expiration = expiration(c.deadline, now)
+            err = s.client.CompareAndSwap(item)
+        }
+        switch {
+        case err == nil:
+            return c, nil
+        case errors.Is(err, memcache.ErrNotStored), errors.Is(err, memcache.ErrCASConflict), errors.Is(err, memcache.ErrCacheMiss):
+            continue
+        default:
+            return counter{}, err
+        }
+    }
+    return counter{}, storage.ErrConflict
+}
+
+// Increment increments the counter for a given key, creating it if it does not exist.
+func (s *Storage) Increment(ctx context.Context, key string) (int, error) {
+    c, err := s.update(ctx, key, func(c *counter, now time.Time) bool {
+        c.value++
+        return true
+    })
+    return c.value, err
+}
+
+// IncrementBy adds n to the counter for a given key, creating it if it does not exist.
+func (s *Storage) IncrementBy(ctx context.Context, key string, n int) (int, error) {
+    c, err := s.update(ctx, key, func(c *counter, now time.Time) bool {
+        c.value += n
+        return true
+    })
+    return c.value, err
+}
+
+// DecrementBy subtracts n from the counter for a given key without taking it below zero.
+// It does not create a missing key and keeps the key's TTL.
+func (s *Storage) DecrementBy(ctx context.Context, key string, n int) (int, error) {
+    c, err := s.update(ctx, key, func(c *counter, now time.Time) bool {
+        if c.value <= 0 || n <= 0 {
+            return false
+        }
+        c.value -= n
+        if c.value < 0 {
+            c.value = 0
+        }
+        return true
+    })
+    return c.value, err
+}
+
+// IncrementWithTTL increments the counter for a given key and, if the key has no expiry yet,
+// sets its time to live to ttl. Both steps are applied in a single compare-and-swap.
+func (s *Storage) IncrementWithTTL(ctx context.Context, key string, ttl time.Duration) (int, error) {
+    c, err := s.update(ctx, key, func(c *counter, now time.Time) bool {
+        c.value++
+        if ttl > 0 && c.deadline.IsZero() {
+            c.deadline = now.Add(ttl)
+        }
+        return true
+    })
+    return c.value, err
+}
+
+// IncrementByAndRenew adds n to the counter for a given key and sets its time to live to ttl, replacing any
+// expiry the key already had. Both steps are applied in a single compare-and-swap.
+func (s *Storage) IncrementByAndRenew(ctx context.Context, key string, n int, ttl time.Duration) (int, error) {
+    c, err := s.update(ctx, key, func(c *counter, now time.Time) bool {
+        c.value += n
+        c.deadline = time.Time{}
+        if ttl > 0 {
+            c.deadline = now.Add(ttl)
+        }
+        return true
+    })
+    return c.value, err
+}
+
+// IncrementWithLimit adds n to the counter for a given key unless the result would exceed limit, and sets the
+// time to live of a key without an expiry to ttl. Both steps are applied in a single compare-and-swap.
+func (s *Storage) IncrementWithLimit(ctx context.Context, key string, n, limit int, ttl time.Duration) (int, time.Duration, bool, error) {
+    var (
+        allowed bool
+        now     time.Time
+    )
+    c, err := s.update(ctx, key, func(c *counter, at time.Time) bool {
+        now = at
+        allowed = c.value+n <= limit
+        if !allowed {
+            return false
+        }
+        c.value += n
+        if ttl > 0 && c.deadline.IsZero() {
+            c.deadline = now.Add(ttl)
+        }
+        return true
+    })
+    if err != nil {
+        return 0, 0, false, err
+    }
+    var remaining time.Duration
+    if !c.deadline.IsZero() {
+        remaining = c.deadline.Sub(now)
+    }
+    return c.value, remaining, allowed, nil
+}
+
+// Reset removes the counter and TTL for a given key.
+func (s *Storage) Reset(ctx context.Context, key string) error {
+    err := s.client.Delete(itemKey(key))
+    if errors.Is(err, memcache.ErrCacheMiss) {
+        return nil
+    }
+    return err
+}
+
+// TTL returns the remaining time to live for a given key.
+// It returns zero if the key does not exist or has no expiry.
+func (s *Storage) TTL(ctx context.Context, key string) (time.Duration, error) {
+    now := time.Now()
+    _, c, err := s.load(key, now)
+    if err != nil || c.deadline.IsZero() {
+        return 0, err
+    }
+    return c.deadline.Sub(now), nil
+}
+
+// SetTTL sets the time to live for a given key. It has no effect if the key does not exist.
+// A non-positive ttl expires the key immediately.
+func (s *Storage) SetTTL(ctx context.Context, key string, ttl time.Duration) error {
+    if ttl <= 0 {
+        return s.Reset(ctx, key)
+    }
+    _, err := s.update(ctx, key, func(c *counter, now time.Time) bool {
+        if c.value == 0 && c.state == nil {
+            return false
+        }
+        c.deadline = now.Add(ttl)
+        return true
+    })
+    return err
+}
+
+// GetState returns the state stored for key, or nil if there is none.
+func (s *Storage) GetState(ctx context.Context, key string) ([]byte, error) {
+    _, c, err := s.load(key, time.Now())
+    return c.state, err
+}
+
+// CompareAndSwapState replaces the state stored for key with new if the current state equals old, and sets the
+// key's time to live to ttl. The comparison and the write are applied in a single compare-and-swap.
+func (s *Storage) CompareAndSwapState(ctx context.Context, key string, old, new []byte, ttl time.Duration) (bool, error) {
+    var swapped bool
+    _, err := s.update(ctx, key, func(c *counter, now time.Time) bool {
+        swapped = bytes.Equal(c.state, old)
+        if !swapped {
+            return false
+        }
+        c.state = append([]byte(nil), new...)
+        c.deadline = time.Time{}
+        if ttl > 0 {
+            c.deadline = now.Add(ttl)
+        }
+        return true
+    })
+    if err != nil {
+        return false, err
+    }
+    return swapped, nil
+}
+
+// Get returns the counter for a given key, or zero if it does not exist.
+func (s *Storage) Get(ctx context.Context, key string) (int, error) {
+    _, c, err := s.load(key, time.Now())
+    return c.value, err
+}
@@ -0,0 +1,372 @@
+package memcached
+
+import (
+    "context"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/bradfitz/gomemcache/memcache"
+)
+
+var _ Client = (*memcache.Client)(nil)
+
+// fakeClient is an in-process stand-in for a Memcached server with compare-and-swap support.
+type fakeClient struct {
+    mu       sync.Mutex
+    values   map[string][]byte
+    versions map[string]uint64
+    issued   map[*memcache.Item]uint64
+    version  uint64
+}
+
+func newFakeClient() *fakeClient {
+    return &fakeClient{
+        values:   make(map[string][]byte),
+        versions: make(map[string]uint64),
+        issued:   make(map[*memcache.Item]uint64),
+    }
+}
+
+func (f *fakeClient) store(key string, value []byte) {
+    f.version++
+    f.values[key] = append([]byte(nil), value...)
+    f.versions[key] = f.version
+}
+
+func (f *fakeClient) Get(key string) (*memcache.Item, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    value, ok := f.values[key]
+    if !ok {
+        return nil, memcache.ErrCacheMiss
+    }
+    item := &memcache.Item{Key: key, Value: append([]byte(nil), value...)}
+    f.issued[item] = f.versions[key]
+    return item, nil
+}
+
+func (f *fakeClient) Add(item *memcache.Item) error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    if _, ok := f.values[item.Key]; ok {
+        return memcache.ErrNotStored
+    }
+    f.store(item.Key, item.Value)
+    return nil
+}
+
+func (f *fakeClient) CompareAndSwap(item *memcache.Item) error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    version, ok := f.versions[item.Key]
+    if !ok {
+        return memcache.ErrNotStored
+    }
+    if f.issued[item] != version {
+        return memcache.ErrCASConflict
+    }
+    f.store(item.Key, item.Value)
+    return nil
+}
+
+func (f *fakeClient) Delete(key string) error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    if _, ok := f.values[key]; !ok {
+        return memcache.ErrCacheMiss
+    }
+    delete(f.values, key)
+    delete(f.versions, key)
+    return nil
+}
+
+func TestStorage_Increment(t *testing.T) {
+    s := New(newFakeClient())
+    ctx := context.Background()
+
+    count, err := s.Get(ctx, "test")
+    if err != nil {
+        t.Fatalf("unexpected error for missing key: %v", err)
+    }
+    if count != 0 {
+        t.Errorf("expected missing key to read as 0, got %d", count)
+    }
+
+    for i := 1; i <= 3; i++ {
+        count, err := s.Increment(ctx, "test")
+        if err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+        if count != i {
+            t.Errorf("expected count %d, got %d", i, count)
+        }
+    }
+
+    if err := s.Reset(ctx, "test"); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if err := s.Reset(ctx, "test"); err != nil {
+        t.Fatalf("unexpected error resetting missing key: %v", err)
+    }
+    count, _ = s.Get(ctx, "test")
+    if count != 0 {
+        t.Errorf("expected count 0 after reset, got %d", count)
+    }
+}
+
+func TestStorage_ConcurrentIncrement(t *testing.T) {
+    s := New(newFakeClient())
+    ctx := context.Background()
+
+    const goroutines, increments = 8, 50
+    var wg sync.WaitGroup
+    for g := 0; g < goroutines; g++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := 0; i < increments; i++ {
+                if _, err := s.Increment(ctx, "test"); err != nil {
+                    t.Errorf("unexpected error: %v", err)
+                }
+            }
+        }()
+    }
+    wg.Wait()
+
+    count, _ := s.Get(ctx, "test")
+    if count != goroutines*increments {
+        t.Errorf("expected count %d, got %d", goroutines*increments, count)
+    }
+}
+
+func TestStorage_TTL(t *testing.T) {
+    s := New(newFakeClient())
+    ctx := context.Background()
+
+    s.Increment(ctx, "test")
+    ttl, err := s.TTL(ctx, "test")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if ttl != 0 {
+        t.Errorf("expected zero TTL for key without expiry, got %s", ttl)
+    }
+
+    if err := s.SetTTL(ctx, "test", time.Minute); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    ttl, _ = s.TTL(ctx, "test")
+    if ttl <= 59*time.Second || ttl > time.Minute {
+        t.Errorf("expected TTL close to 1m, got %s", ttl)
+    }
+
+    if err := s.SetTTL(ctx, "test", 10*time.Millisecond); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    time.Sleep(20 * time.Millisecond)
+
+    count, _ := s.Get(ctx, "test")
+    if count != 0 {
+        t.Errorf("expected expired key to read as 0, got %d", count)
+    }
+    count, _ = s.Increment(ctx, "test")
+    if count != 1 {
+        t.Errorf("expected expired key to restart at 1, got %d", count)
+    }
+
+    if err := s.SetTTL(ctx, "missing", time.Minute); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if _, err := s.client.Get("missing"); err != memcache.ErrCacheMiss {
+        t.Errorf("expected SetTTL not to create missing key, got %v", err)
+    }
+}
+
+func TestStorage_DecrementBy(t *testing.T) {
+    s := New(newFakeClient())
+    ctx := context.Background()
+
+    count, err := s.DecrementBy(ctx, "test", 2)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if count != 0 {
+        t.Errorf("expected missing key to read as 0, got %d", count)
+    }
+    if _, err := s.client.Get("test"); err != memcache.ErrCacheMiss {
+        t.Errorf("expected DecrementBy not to create missing key, got %v", err)
+    }
+
+    s.IncrementBy(ctx, "test", 5)
+    s.SetTTL(ctx, "test", time.Minute)
+
+    count, _ = s.DecrementBy(ctx, "test", 2)
+    if count != 3 {
+        t.Errorf("expected count 3, got %d", count)
+    }
+    count, _ = s.DecrementBy(ctx, "test", 10)
+    if count != 0 {
+        t.Errorf("expected count to stop at 0, got %d", count)
+    }
+    if ttl, _ := s.TTL(ctx, "test"); ttl <= 59*time.Second {
+        t.Errorf("expected DecrementBy to keep the TTL, got %s", ttl)
+    }
+}
+
+func TestStorage_IncrementWithTTL(t *testing.T) {
+    s := New(newFakeClient())
+    ctx := context.Background()
+
+    count, err := s.IncrementWithTTL(ctx, "test", time.Minute)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if count != 1 {
+        t.Errorf("expected count 1, got %d", count)
+    }
+
+    s.SetTTL(ctx, "test", time.Hour)
+    count, _ = s.IncrementWithTTL(ctx, "test", time.Minute)
+    if count != 2 {
+        t.Errorf("expected count 2, got %d", count)
+    }
+    ttl, _ := s.TTL(ctx, "test")
+    if ttl <= time.Minute {
+        t.Errorf("expected existing TTL to be kept, got %s", ttl)
+    }
+}
+
+func TestStorage_IncrementByAndRenew(t *testing.T) {
+    s := New(newFakeClient())
+    ctx := context.Background()
+
+    count, err := s.IncrementByAndRenew(ctx, "test", 3, time.Hour)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if count != 3 {
+        t.Errorf("expected count 3, got %d", count)
+    }
+
+    count, _ = s.IncrementByAndRenew(ctx, "test", 2, time.Minute)
+    if count != 5 {
+        t.Errorf("expected count 5, got %d", count)
+    }
+    ttl, _ := s.TTL(ctx, "test")
+    if ttl <= 59*time.Second || ttl > time.Minute {
+        t.Errorf("expected the TTL to be restarted at 1m, got %s", ttl)
+    }
+}
+
+func TestStorage_IncrementWithLimit(t *testing.T) {
+    s := New(newFakeClient())
+    ctx := context.Background()
+
+    count, ttl, allowed, err := s.IncrementWithLimit(ctx, "test", 4, 5, time.Minute)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if count != 4 || !allowed || ttl <= 59*time.Second {
+        t.Errorf("expected (4, ~1m, true), got (%d, %s, %v)", count, ttl, allowed)
+    }
+
+    count, _, allowed, _ = s.IncrementWithLimit(ctx, "test", 2, 5, time.Minute)
+    if count != 4 || allowed {
+        t.Errorf("expected (4, false), got (%d, %v)", count, allowed)
+    }
+
+    count, _, allowed, _ = s.IncrementWithLimit(ctx, "test", 1, 5, time.Minute)
+    if count != 5 || !allowed {
+        t.Errorf("expected (5, true), got (%d, %v)", count, allowed)
+    }
+}
+
+func TestExpiration(t *testing.T) {
+    now := time.Now()
+    tests := []struct {
+        deadline time.Time
+        want     int32
+    }{
+        {time.Time{}, 0},
+        {now.Add(1500 * time.Millisecond), 2},
+        {now.Add(time.Millisecond), 1},
+        {now.Add(60 * 24 * time.Hour), int32(now.Add(60*24*time.Hour).Unix() + 1)},
+    }
+    for _, tt := range tests {
+        if got := expiration(tt.deadline, now); got != tt.want {
+            t.Errorf("expiration(%s) = %d, want %d", tt.deadline, got, tt.want)
+        }
+    }
+}
+
+func TestStorage_State(t *testing.T) {
+    s := New(newFakeClient())
+    ctx := context.Background()
+
+    state, err := s.GetState(ctx, "state")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if state != nil {
+        t.Errorf("expected no state for missing key, got %q", state)
+    }
+
+    swapped, err := s.CompareAndSwapState(ctx, "state", nil, []byte("a:1"), time.Minute)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if !swapped {
+        t.Errorf("expected swap on missing key to succeed")
+    }
+    if ttl, _ := s.TTL(ctx, "state"); ttl <= 59*time.Second || ttl > time.Minute {
+        t.Errorf("expected TTL close to 1m, got %s", ttl)
+    }
+
+    swapped, _ = s.CompareAndSwapState(ctx, "state", nil, []byte("b"), time.Minute)
+    if swapped {
+        t.Errorf("expected swap with stale state to fail")
+    }
+
+    swapped, _ = s.CompareAndSwapState(ctx, "state", []byte("a:1"), []byte("b"), 0)
+    if !swapped {
+        t.Errorf("expected swap with current state to succeed")
+    }
+    state, _ = s.GetState(ctx, "state")
+    if string(state) != "b" {
+        t.Errorf("expected state %q, got %q", "b", state)
+    }
+    if ttl, _ := s.TTL(ctx, "state"); ttl != 0 {
+        t.Errorf("expected no TTL, got %s", ttl)
+    }
+}
+
+func TestItemKey(t *testing.T) {
+    tests := []struct {
+        key  string
+        want string
+    }{
+        {"user:42", "user:42"},
+        {"a b", "a%20b"},
+        {"\x00grant:user:42", "%00grant:user:42"},
+        {"100%", "100%25"},
+        {"%00grant:user:42", "%2500grant:user:42"},
+    }
+    for _, tt := range tests {
+        if got := itemKey(tt.key); got != tt.want {
+            t.Errorf("itemKey(%q) = %q, expected %q", tt.key, got, tt.want)
+        }
+    }
+
+    s := New(newFakeClient())
+    ctx := context.Background()
+    s.IncrementBy(ctx, "\x00grant:a b", 3)
+    s.Increment(ctx, "%00grant:a%20b")
+    if count, _ := s.Get(ctx, "\x00grant:a b"); count != 3 {
+        t.Errorf("expected escaped keys to be kept apart, got %d", count)
+    }
+}
@@ -0,0 +1,332 @@
+package storage
+
+import (
+    "context"
+    "sync"
+    "time"
+)
+
+// DefaultSweepInterval is the interval at which the in-memory storages remove expired keys in the background.
+const DefaultSweepInterval = time.Minute
+
+// entry is a counter, sorted set or state value together with its expiry deadline. A zero expiresAt means the key never expires.
+type entry struct {
+    value     int
+    members   map[string]int64
+    state     []byte
+    expiresAt time.Time
+}
+
+// expired reports whether the entry has expired at the given time.
+func (e *entry) expired(now time.Time) bool {
+    return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// ttl returns the time to live left at the given time, or zero if the entry never expires.
+func (e *entry) ttl(now time.Time) time.Duration {
+    if e.expiresAt.IsZero() {
+        return 0
+    }
+    return e.expiresAt.Sub(now)
+}
+
+// shard is a mutex-protected set of entries. It implements the semantics shared by the in-memory storages.
+type shard struct {
+    data map[string]*entry
+    mu   sync.Mutex
+}
+
+// newShard creates an empty shard.
+func newShard() *shard {
+    return &shard{
+        data: make(map[string]*entry),
+    }
+}
+
+// lookup returns the live entry for key, removing it if it has expired. The caller must hold sh.mu.
+func (sh *shard) lookup(key string, now time.Time) *entry {
+    e, ok := sh.data[key]
+    if !ok {
+        return nil
+    }
+    if e.expired(now) {
+        delete(sh.data, key)
+        return nil
+    }
+    return e
+}
+
+func (sh *shard) increment(key string, n int) int {
+    return sh.incrementWithTTL(key, n, 0)
+}
+
+// incrementWithTTL adds n to the counter for key and, if ttl is positive and the key has no expiry yet, sets it to ttl.
+func (sh *shard) incrementWithTTL(key string, n int, ttl time.Duration) int {
+    sh.mu.Lock()
+    defer sh.mu.Unlock()
+
+    now := time.Now()
+    e := sh.lookup(key, now)
+    if e == nil {
+        e = &entry{}
+        sh.data[key] = e
+    }
+    e.value += n
+    if ttl > 0 && e.expiresAt.IsZero() {
+        e.expiresAt = now.Add(ttl)
+    }
+    return e.value
+}
+
+// incrementAndRenew adds n to the counter for key and sets its expiry to ttl from now. A non-positive ttl removes
+// the expiry.
+func (sh *shard) incrementAndRenew(key string, n int, ttl time.Duration) int {
+    sh.mu.Lock()
+    defer sh.mu.Unlock()
+
+    now := time.Now()
+    e := sh.lookup(key, now)
+    if e == nil {
+        e = &entry{}
+        sh.data[key] = e
+    }
+    e.value += n
+    e.expiresAt = time.Time{}
+    if ttl > 0 {
+        e.expiresAt = now.Add(ttl)
+    }
+    return e.value
+}
+
+func (sh *shard) decrement(key string, n int) int {
+    sh.mu.Lock()
+    defer sh.mu.Unlock()
+
+    e := sh.lookup(key, time.Now())
+    if e == nil {
+        return 0
+    }
+    if n > 0 {
+        e.value -= n
+    }
+    if e.value < 0 {
+        e.value = 0
+    }
+    return e.value
+}
+
+// incrementWithLimit adds n to the counter for key unless the result would exceed limit.
+// It returns the counter value and remaining TTL after the call, and whether n was added.
+func (sh *shard) incrementWithLimit(key string, n, limit int, ttl time.Duration) (int, time.Duration, bool) {
+    sh.mu.Lock()
+    defer sh.mu.Unlock()
+
+    now := time.Now()
+    e := sh.lookup(key, now)
+    var count int
+    if e != nil {
+        count = e.value
+    }
+    if count+n > limit {
+        if e == nil {
+            return count, 0, false
+        }
+        return count, e.ttl(now), false
+    }
+
+    if e == nil {
+        e = &entry{}
+        sh.data[key] = e
+    }
+    e.value += n
+    if ttl > 0 && e.expiresAt.IsZero() {
+        e.expiresAt = now.Add(ttl)
+    }
+    return e.value, e.ttl(now), true
+}
+
+func (sh *shard) reset(key string) {
+    sh.mu.Lock()
+    defer sh.mu.Unlock()
+
+    delete(sh.data, key)
+}
+
+func (sh *shard) ttl(key string) time.Duration {
+    sh.mu.Lock()
+    defer sh.mu.Unlock()
+
+    now := time.Now()
+    e := sh.lookup(key, now)
+    if e == nil {
+        return 0
+    }
+    return e.ttl(now)
+}
+
+func (sh *shard) setTTL(key string, ttl time.Duration) {
+    sh.mu.Lock()
+    defer sh.mu.Unlock()
+
+    now := time.Now()
+    e := sh.lookup(key, now)
+    if e == nil {
+        return
+    }
+    if ttl <= 0 {
+        delete(sh.data, key)
+        return
+    }
+    e.expiresAt = now.Add(ttl)
+}
+
+func (sh *shard) get(key string) int {
+    sh.mu.Lock()
+    defer sh.mu.Unlock()
+
+    e := sh.lookup(key, time.Now())
+    if e == nil {
+        return 0
+    }
+    return e.value
+}
+
+// deleteExpired removes all keys whose TTL has elapsed.
+func (sh *shard) deleteExpired() {
+    sh.mu.Lock()
+    defer sh.mu.Unlock()
+
+    now := time.Now()
+    for key, e := range sh.data {
+        if e.expired(now) {
+            delete(sh.data, key)
+        }
+    }
+}
+
+// sweeper periodically removes expired keys from a set of shards until it is closed.
+type sweeper struct {
+    stop      chan struct{}
+    closeOnce sync.Once
+}
+
+// newSweeper starts sweeping the given shards at the given interval.
+// A non-positive interval returns a sweeper that never runs.
+func newSweeper(interval time.Duration, shards []*shard) *sweeper {
+    sw := &sweeper{
+        stop: make(chan struct{}),
+    }
+    if interval > 0 {
+        go sw.run(interval, shards)
+    }
+    return sw
+}
+
+func (sw *sweeper) run(interval time.Duration, shards []*shard) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ticker.C:
+            for _, sh := range shards {
+                sh.deleteExpired()
+            }
+        case <-sw.stop:
+            return
+        }
+    }
+}
+
+// Close stops the sweeper. It is safe to call Close more than once.
+func (sw *sweeper) Close() error {
+    sw.closeOnce.Do(func() {
+        close(sw.stop)
+    })
+    return nil
+}
+
+// InMemoryStorage is an in-process implementation of the Storage interface guarded by a single mutex.
+// Expired keys are removed lazily when accessed and periodically by a background sweeper.
+type InMemoryStorage struct {
+    shard   *shard
+    sweeper *sweeper
+}
+
+// NewInMemoryStorage creates a new InMemoryStorage that sweeps expired keys every DefaultSweepInterval.
+func NewInMemoryStorage() *InMemoryStorage {
+    return NewInMemoryStorageWithSweepInterval(DefaultSweepInterval)
+}
+
+// NewInMemoryStorageWithSweepInterval creates a new InMemoryStorage that sweeps expired keys at the given interval.
+// A non-positive interval disables the background sweeper; expired keys are then only removed when accessed.
+func NewInMemoryStorageWithSweepInterval(interval time.Duration) *InMemoryStorage {
+    sh := newShard()
+    return &InMemoryStorage{
+        shard:   sh,
+        sweeper: newSweeper(interval, []*shard{sh}),
+    }
+}
+
+// Close stops the background sweeper. It is safe to call Close more than once.
+func (s *InMemoryStorage) Close() error {
+    return s.sweeper.Close()
+}
+
+// Increment increments the counter for a given key, creating it if it does not exist.
+func (s *InMemoryStorage) Increment(ctx context.Context, key string) (int, error) {
+    return s.shard.increment(key, 1), nil
+}
+
+// IncrementBy adds n to the counter for a given key, creating it if it does not exist.
+func (s *InMemoryStorage) IncrementBy(ctx context.Context, key string, n int) (int, error) {
+    return s.shard.increment(key, n), nil
+}
+
+// DecrementBy subtracts n from the counter for a given key without taking it below zero.
+// It does not create a missing key and keeps the key's TTL.
+func (s *InMemoryStorage) DecrementBy(ctx context.Context, key string, n int) (int, error) {
+    return s.shard.decrement(key, n), nil
+}
+
+// IncrementWithTTL increments the counter for a given key and, if the key has no expiry yet,
+// sets its time to live to ttl. Both steps are applied atomically.
+func (s *InMemoryStorage) IncrementWithTTL(ctx context.Context, key string, ttl time.Duration) (int, error) {
+    return s.shard.incrementWithTTL(key, 1, ttl), nil
+}
+
+// IncrementByAndRenew adds n to the counter for a given key and sets its time to live to ttl, replacing any
+// expiry the key already had. Both steps are applied atomically.
+func (s *InMemoryStorage) IncrementByAndRenew(ctx context.Context, key string, n int, ttl time.Duration) (int, error) {
+    return s.shard.incrementAndRenew(key, n, ttl), nil
+}
+
+// IncrementWithLimit adds n to the counter for a given key unless the result would exceed limit, and sets the
+// time to live of a key without an expiry to ttl. Both steps are applied atomically.
+func (s *InMemoryStorage) IncrementWithLimit(ctx context.Context, key string, n, limit int, ttl time.Duration) (int, time.Duration, bool, error) {
+    count, remaining, ok := s.shard.incrementWithLimit(key, n, limit, ttl)
+    return count, remaining, ok, nil
+}
+
+// Reset removes the counter and TTL for a given key.
+func (s *InMemoryStorage) Reset(ctx context.Context, key string) error {
+    s.shard.reset(key)
+    return nil
+}
+
+// TTL returns the remaining time to live for a given key.
+// It returns zero if the key does not exist or has no expiry.
+func (s *InMemoryStorage) TTL(ctx context.Context, key string) (time.Duration, error) {
+    return s.shard.ttl(key), nil
+}
+
+// SetTTL sets the time to live for a given key. It has no effect if the key does not exist.
+// A non-positive ttl expires the key immediately.
+func (s *InMemoryStorage) SetTTL(ctx context.Context, key string, ttl time.Duration) error {
+    s.shard.setTTL(key, ttl)
+    return nil
+}
+
+// Get returns the counter for a given key, or zero if it does not exist.
+func (s *InMemoryStorage) Get(ctx context.Context, key string) (int, error) {
+    return s.shard.get(key), nil
+}
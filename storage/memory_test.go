@@ -0,0 +1,149 @@
+package storage
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+var (
+    _ Storage             = (*InMemoryStorage)(nil)
+    _ AtomicIncrementer   = (*InMemoryStorage)(nil)
+    _ LimitedIncrementer  = (*InMemoryStorage)(nil)
+    _ RenewingIncrementer = (*InMemoryStorage)(nil)
+)
+
+func TestInMemoryStorage_Increment(t *testing.T) {
+    s := NewInMemoryStorage()
+    defer s.Close()
+
+    ctx := context.Background()
+    for i := 1; i <= 3; i++ {
+        count, err := s.Increment(ctx, "test")
+        if err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+        if count != i {
+            t.Errorf("expected count %d, got %d", i, count)
+        }
+    }
+
+    count, err := s.Get(ctx, "test")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if count != 3 {
+        t.Errorf("expected count 3, got %d", count)
+    }
+
+    if err := s.Reset(ctx, "test"); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    count, _ = s.Get(ctx, "test")
+    if count != 0 {
+        t.Errorf("expected count 0 after reset, got %d", count)
+    }
+}
+
+func TestInMemoryStorage_DecrementBy(t *testing.T) {
+    s := NewInMemoryStorage()
+    defer s.Close()
+
+    ctx := context.Background()
+    count, err := s.DecrementBy(ctx, "test", 2)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if count != 0 {
+        t.Errorf("expected missing key to read as 0, got %d", count)
+    }
+    if ttl, _ := s.TTL(ctx, "test"); ttl != 0 {
+        t.Errorf("expected DecrementBy not to create the key")
+    }
+
+    s.IncrementBy(ctx, "test", 5)
+    s.SetTTL(ctx, "test", time.Minute)
+
+    count, _ = s.DecrementBy(ctx, "test", 2)
+    if count != 3 {
+        t.Errorf("expected count 3, got %d", count)
+    }
+    count, _ = s.DecrementBy(ctx, "test", 10)
+    if count != 0 {
+        t.Errorf("expected count to stop at 0, got %d", count)
+    }
+    if ttl, _ := s.TTL(ctx, "test"); ttl <= 0 {
+        t.Errorf("expected DecrementBy to keep the TTL, got %s", ttl)
+    }
+}
+
+func TestInMemoryStorage_TTL(t *testing.T) {
+    s := NewInMemoryStorageWithSweepInterval(0)
+    defer s.Close()
+
+    ctx := context.Background()
+    ttl, _ := s.TTL(ctx, "test")
+    if ttl != 0 {
+        t.Errorf("expected zero TTL for missing key, got %s", ttl)
+    }
+
+    s.Increment(ctx, "test")
+    ttl, _ = s.TTL(ctx, "test")
+    if ttl != 0 {
+        t.Errorf("expected zero TTL for key without expiry, got %s", ttl)
+    }
+
+    if err := s.SetTTL(ctx, "test", 20*time.Millisecond); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    ttl, _ = s.TTL(ctx, "test")
+    if ttl <= 0 || ttl > 20*time.Millisecond {
+        t.Errorf("expected TTL in (0, 20ms], got %s", ttl)
+    }
+
+    time.Sleep(30 * time.Millisecond)
+
+    count, _ := s.Get(ctx, "test")
+    if count != 0 {
+        t.Errorf("expected expired key to read as 0, got %d", count)
+    }
+    count, _ = s.Increment(ctx, "test")
+    if count != 1 {
+        t.Errorf("expected expired key to restart at 1, got %d", count)
+    }
+    ttl, _ = s.TTL(ctx, "test")
+    if ttl != 0 {
+        t.Errorf("expected recreated key to have no expiry, got %s", ttl)
+    }
+}
+
+func TestInMemoryStorage_Sweep(t *testing.T) {
+    s := NewInMemoryStorageWithSweepInterval(10 * time.Millisecond)
+    defer s.Close()
+
+    ctx := context.Background()
+    for _, key := range []string{"a", "b", "c"} {
+        s.Increment(ctx, key)
+        s.SetTTL(ctx, key, 5*time.Millisecond)
+    }
+    s.Increment(ctx, "persistent")
+
+    time.Sleep(50 * time.Millisecond)
+
+    s.shard.mu.Lock()
+    n := len(s.shard.data)
+    s.shard.mu.Unlock()
+    if n != 1 {
+        t.Errorf("expected sweeper to leave 1 key, got %d", n)
+    }
+}
+
+func TestInMemoryStorage_Close(t *testing.T) {
+    s := NewInMemoryStorage()
+    if err := s.Close(); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if err := s.Close(); err != nil {
+        t.Fatalf("unexpected error on second close: %v", err)
+    }
+}
@@ -0,0 +1,286 @@
+package redis
+
+import (
+    "context"
+    "errors"
+    "strconv"
+    "time"
+
+    goredis "github.com/redis/go-redis/v9"
+    "github.com/umbeluzi/ratelimit/storage"
+)
+
+var (
+    _ storage.Storage             = (*Storage)(nil)
+    _ storage.AtomicIncrementer   = (*Storage)(nil)
+    _ storage.LimitedIncrementer  = (*Storage)(nil)
+    _ storage.RenewingIncrementer = (*Storage)(nil)
+    _ storage.SortedSetStorage    = (*Storage)(nil)
+    _ storage.StateStorage        = (*Storage)(nil)
+)
+
+// incrementWithTTL increments KEYS[1] and, if the key has no expiry yet, sets it to ARGV[1] milliseconds.
+// Running both steps in one script guarantees that a counter is never left without a TTL.
+var incrementWithTTL = goredis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if redis.call("PTTL", KEYS[1]) == -1 then
+    redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return count
+`)
+
+// incrementByAndRenew adds ARGV[1] to KEYS[1] and sets its expiry to ARGV[2] milliseconds, so the new count and
+// its expiry are written together.
+var incrementByAndRenew = goredis.NewScript(`
+local count = redis.call("INCRBY", KEYS[1], ARGV[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+return count
+`)
+
+// incrementWithLimit adds ARGV[1] to KEYS[1] unless the result would exceed ARGV[2], and sets the expiry of a key
+// without one to ARGV[3] milliseconds. It returns the counter, its remaining TTL in milliseconds and 1 if the
+// increment was applied or 0 otherwise.
+var incrementWithLimit = goredis.NewScript(`
+local count = tonumber(redis.call("GET", KEYS[1]) or "0")
+local n = tonumber(ARGV[1])
+if count + n > tonumber(ARGV[2]) then
+    return {count, redis.call("PTTL", KEYS[1]), 0}
+end
+count = redis.call("INCRBY", KEYS[1], n)
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl == -1 and tonumber(ARGV[3]) > 0 then
+    redis.call("PEXPIRE", KEYS[1], ARGV[3])
+    ttl = tonumber(ARGV[3])
+end
+return {count, ttl, 1}
+`)
+
+// decrementBy subtracts ARGV[1] from KEYS[1] without taking it below zero. A missing key is not created,
+// and DECRBY keeps the key's TTL.
+var decrementBy = goredis.NewScript(`
+local count = tonumber(redis.call("GET", KEYS[1]) or "0")
+local n = math.min(count, tonumber(ARGV[1]))
+if n > 0 then
+    count = redis.call("DECRBY", KEYS[1], n)
+end
+return count
+`)
+
+// compareAndSwapState sets KEYS[1] to ARGV[2] if its current value equals ARGV[1], treating a missing key as an
+// empty value, and expires it after ARGV[3] milliseconds if positive. It returns 1 if the value was replaced.
+var compareAndSwapState = goredis.NewScript(`
+if (redis.call("GET", KEYS[1]) or "") ~= ARGV[1] then
+    return 0
+end
+if tonumber(ARGV[3]) > 0 then
+    redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+else
+    redis.call("SET", KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// Storage is a Redis implementation of the storage.Storage interface.
+type Storage struct {
+    client goredis.UniversalClient
+}
+
+// New creates a new Storage backed by the given Redis client.
+func New(client goredis.UniversalClient) *Storage {
+    return &Storage{client: client}
+}
+
+// Increment increments the counter for a given key, creating it if it does not exist.
+func (s *Storage) Increment(ctx context.Context, key string) (int, error) {
+    result, err := s.client.Incr(ctx, key).Result()
+    return int(result), err
+}
+
+// IncrementBy adds n to the counter for a given key, creating it if it does not exist.
+func (s *Storage) IncrementBy(ctx context.Context, key string, n int) (int, error) {
+    result, err := s.client.IncrBy(ctx, key, int64(n)).Result()
+    return int(result), err
+}
+
+// DecrementBy subtracts n from the counter for a given key without taking it below zero. It does not create a
+// missing key and keeps the key's TTL. Both are guaranteed by running it as a single script.
+func (s *Storage) DecrementBy(ctx context.Context, key string, n int) (int, error) {
+    return decrementBy.Run(ctx, s.client, []string{key}, n).Int()
+}
+
+// IncrementWithTTL increments the counter for a given key and, if the key has no expiry yet,
+// sets its time to live to ttl. Both steps are applied atomically in a single round-trip.
+func (s *Storage) IncrementWithTTL(ctx context.Context, key string, ttl time.Duration) (int, error) {
+    if ttl <= 0 {
+        return s.Increment(ctx, key)
+    }
+    result, err := incrementWithTTL.Run(ctx, s.client, []string{key}, milliseconds(ttl)).Int()
+    return result, err
+}
+
+// IncrementByAndRenew adds n to the counter for a given key and sets its time to live to ttl, replacing any
+// expiry the key already had. Both steps are applied atomically in a single round-trip.
+func (s *Storage) IncrementByAndRenew(ctx context.Context, key string, n int, ttl time.Duration) (int, error) {
+    if ttl <= 0 {
+        result, err := s.client.IncrBy(ctx, key, int64(n)).Result()
+        if err != nil {
+            return 0, err
+        }
+        return int(result), s.client.Persist(ctx, key).Err()
+    }
+    return incrementByAndRenew.Run(ctx, s.client, []string{key}, n, milliseconds(ttl)).Int()
+}
+
+// IncrementWithLimit adds n to the counter for a given key unless the result would exceed limit, and sets the
+// time to live of a key without an expiry to ttl. Both steps are applied atomically in a single round-trip.
+func (s *Storage) IncrementWithLimit(ctx context.Context, key string, n, limit int, ttl time.Duration) (int, time.Duration, bool, error) {
+    var ms int64
+    if ttl > 0 {
+        ms = milliseconds(ttl)
+    }
+    result, err := incrementWithLimit.Run(ctx, s.client, []string{key}, n, limit, ms).Int64Slice()
+    if err != nil {
+        return 0, 0, false, err
+    }
+    remaining := time.Duration(result[1]) * time.Millisecond
+    if remaining < 0 {
+        remaining = 0
+    }
+    return int(result[0]), remaining, result[2] == 1, nil
+}
+
+// Reset removes the counter and TTL for a given key.
+func (s *Storage) Reset(ctx context.Context, key string) error {
+    return s.client.Del(ctx, key).Err()
+}
+
+// TTL returns the remaining time to live for a given key.
+// It returns zero if the key does not exist or has no expiry.
+func (s *Storage) TTL(ctx context.Context, key string) (time.Duration, error) {
+    result, err := s.client.PTTL(ctx, key).Result()
+    if err != nil {
+        return 0, err
+    }
+    if result < 0 {
+        return 0, nil
+    }
+    return result, nil
+}
+
+// SetTTL sets the time to live for a given key. It has no effect if the key does not exist.
+// A non-positive ttl expires the key immediately.
+func (s *Storage) SetTTL(ctx context.Context, key string, ttl time.Duration) error {
+    return s.client.PExpire(ctx, key, ttl).Err()
+}
+
+// Get returns the counter for a given key, or zero if it does not exist.
+func (s *Storage) Get(ctx context.Context, key string) (int, error) {
+    result, err := s.client.Get(ctx, key).Int()
+    if errors.Is(err, goredis.Nil) {
+        return 0, nil
+    }
+    return result, err
+}
+
+// AddMembers adds members with the given score to the sorted set stored at key and sets the key's time to live
+// to ttl. Both steps are applied in a single transaction.
+func (s *Storage) AddMembers(ctx context.Context, key string, score int64, ttl time.Duration, members ...string) error {
+    if len(members) == 0 {
+        return nil
+    }
+    zs := make([]goredis.Z, len(members))
+    for i, member := range members {
+        zs[i] = goredis.Z{Score: float64(score), Member: member}
+    }
+    _, err := s.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+        pipe.ZAdd(ctx, key, zs...)
+        if ttl > 0 {
+            pipe.PExpire(ctx, key, ttl)
+        }
+        return nil
+    })
+    return err
+}
+
+// RemoveMembers removes members from the sorted set stored at key.
+func (s *Storage) RemoveMembers(ctx context.Context, key string, members ...string) error {
+    if len(members) == 0 {
+        return nil
+    }
+    args := make([]interface{}, len(members))
+    for i, member := range members {
+        args[i] = member
+    }
+    return s.client.ZRem(ctx, key, args...).Err()
+}
+
+// RemoveLastMembers removes the n members with the highest scores and returns how many were removed.
+func (s *Storage) RemoveLastMembers(ctx context.Context, key string, n int) (int, error) {
+    if n <= 0 {
+        return 0, nil
+    }
+    result, err := s.client.ZPopMax(ctx, key, int64(n)).Result()
+    return len(result), err
+}
+
+// RemoveMembersByScore removes the members whose score lies within [min, max] and returns how many were removed.
+func (s *Storage) RemoveMembersByScore(ctx context.Context, key string, min, max int64) (int, error) {
+    result, err := s.client.ZRemRangeByScore(ctx, key, score(min), score(max)).Result()
+    return int(result), err
+}
+
+// CountMembers returns the number of members whose score lies within [min, max].
+func (s *Storage) CountMembers(ctx context.Context, key string, min, max int64) (int, error) {
+    result, err := s.client.ZCount(ctx, key, score(min), score(max)).Result()
+    return int(result), err
+}
+
+// Scores returns, in ascending order, the scores of at most limit members whose score lies within [min, max].
+func (s *Storage) Scores(ctx context.Context, key string, min, max int64, limit int) ([]int64, error) {
+    if limit == 0 {
+        return nil, nil
+    }
+    by := &goredis.ZRangeBy{Min: score(min), Max: score(max)}
+    if limit > 0 {
+        by.Count = int64(limit)
+    }
+    result, err := s.client.ZRangeByScoreWithScores(ctx, key, by).Result()
+    if err != nil {
+        return nil, err
+    }
+    scores := make([]int64, len(result))
+    for i, z := range result {
+        scores[i] = int64(z.Score)
+    }
+    return scores, nil
+}
+
+// GetState returns the state stored for key, or nil if there is none.
+func (s *Storage) GetState(ctx context.Context, key string) ([]byte, error) {
+    result, err := s.client.Get(ctx, key).Bytes()
+    if errors.Is(err, goredis.Nil) {
+        return nil, nil
+    }
+    return result, err
+}
+
+// CompareAndSwapState replaces the state stored for key with new if the current state equals old, and sets the
+// key's time to live to ttl. The comparison and the write are applied atomically in a single round-trip.
+func (s *Storage) CompareAndSwapState(ctx context.Context, key string, old, new []byte, ttl time.Duration) (bool, error) {
+    var ms int64
+    if ttl > 0 {
+        ms = milliseconds(ttl)
+    }
+    result, err := compareAndSwapState.Run(ctx, s.client, []string{key}, old, new, ms).Int()
+    return result == 1, err
+}
+
+// score formats a sorted set score bound for ZRANGEBYSCORE and related commands.
+func score(n int64) string {
+    return strconv.FormatInt(n, 10)
+}
+
+// milliseconds converts d to whole milliseconds, rounding up so that sub-millisecond TTLs do not expire keys immediately.
+func milliseconds(d time.Duration) int64 {
+    return int64((d + time.Millisecond - 1) / time.Millisecond)
+}
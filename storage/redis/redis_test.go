@@ -0,0 +1,298 @@
+package redis
+
+import (
+    "context"
+    "math"
+    "reflect"
+    "testing"
+    "time"
+
+    "github.com/alicebob/miniredis/v2"
+    goredis "github.com/redis/go-redis/v9"
+)
+
+func newTestStorage(t *testing.T) (*Storage, *miniredis.Miniredis) {
+    server := miniredis.RunT(t)
+    client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+    t.Cleanup(func() { client.Close() })
+    return New(client), server
+}
+
+func TestStorage_Increment(t *testing.T) {
+    s, _ := newTestStorage(t)
+    ctx := context.Background()
+
+    count, err := s.Get(ctx, "test")
+    if err != nil {
+        t.Fatalf("unexpected error for missing key: %v", err)
+    }
+    if count != 0 {
+        t.Errorf("expected missing key to read as 0, got %d", count)
+    }
+
+    for i := 1; i <= 3; i++ {
+        count, err := s.Increment(ctx, "test")
+        if err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+        if count != i {
+            t.Errorf("expected count %d, got %d", i, count)
+        }
+    }
+
+    if err := s.Reset(ctx, "test"); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    count, _ = s.Get(ctx, "test")
+    if count != 0 {
+        t.Errorf("expected count 0 after reset, got %d", count)
+    }
+}
+
+func TestStorage_DecrementBy(t *testing.T) {
+    s, server := newTestStorage(t)
+    ctx := context.Background()
+
+    count, err := s.DecrementBy(ctx, "test", 2)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if count != 0 || server.Exists("test") {
+        t.Errorf("expected missing key to stay missing, got %d", count)
+    }
+
+    s.IncrementBy(ctx, "test", 5)
+    s.SetTTL(ctx, "test", time.Minute)
+
+    count, _ = s.DecrementBy(ctx, "test", 2)
+    if count != 3 {
+        t.Errorf("expected count 3, got %d", count)
+    }
+    count, _ = s.DecrementBy(ctx, "test", 10)
+    if count != 0 {
+        t.Errorf("expected count to stop at 0, got %d", count)
+    }
+    if ttl := server.TTL("test"); ttl != time.Minute {
+        t.Errorf("expected DecrementBy to keep the TTL, got %s", ttl)
+    }
+}
+
+func TestStorage_IncrementWithTTL(t *testing.T) {
+    s, server := newTestStorage(t)
+    ctx := context.Background()
+
+    count, err := s.IncrementWithTTL(ctx, "test", time.Minute)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if count != 1 {
+        t.Errorf("expected count 1, got %d", count)
+    }
+    if ttl := server.TTL("test"); ttl != time.Minute {
+        t.Errorf("expected TTL of 1m, got %s", ttl)
+    }
+
+    server.FastForward(30 * time.Second)
+
+    count, _ = s.IncrementWithTTL(ctx, "test", time.Minute)
+    if count != 2 {
+        t.Errorf("expected count 2, got %d", count)
+    }
+    ttl, err := s.TTL(ctx, "test")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if ttl != 30*time.Second {
+        t.Errorf("expected existing TTL to be kept at 30s, got %s", ttl)
+    }
+
+    server.FastForward(30 * time.Second)
+
+    count, _ = s.Get(ctx, "test")
+    if count != 0 {
+        t.Errorf("expected expired key to read as 0, got %d", count)
+    }
+}
+
+func TestStorage_IncrementByAndRenew(t *testing.T) {
+    s, server := newTestStorage(t)
+    ctx := context.Background()
+
+    count, err := s.IncrementByAndRenew(ctx, "test", 3, time.Hour)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if count != 3 {
+        t.Errorf("expected count 3, got %d", count)
+    }
+
+    count, _ = s.IncrementByAndRenew(ctx, "test", 2, time.Minute)
+    if count != 5 {
+        t.Errorf("expected count 5, got %d", count)
+    }
+    if ttl := server.TTL("test"); ttl != time.Minute {
+        t.Errorf("expected the TTL to be restarted at 1m, got %s", ttl)
+    }
+
+    count, _ = s.IncrementByAndRenew(ctx, "test", 1, 0)
+    if ttl := server.TTL("test"); count != 6 || ttl != 0 {
+        t.Errorf("expected count 6 without a TTL, got %d with TTL %s", count, ttl)
+    }
+}
+
+func TestStorage_TTL(t *testing.T) {
+    s, _ := newTestStorage(t)
+    ctx := context.Background()
+
+    ttl, err := s.TTL(ctx, "test")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if ttl != 0 {
+        t.Errorf("expected zero TTL for missing key, got %s", ttl)
+    }
+
+    s.Increment(ctx, "test")
+    ttl, _ = s.TTL(ctx, "test")
+    if ttl != 0 {
+        t.Errorf("expected zero TTL for key without expiry, got %s", ttl)
+    }
+
+    if err := s.SetTTL(ctx, "test", time.Minute); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    ttl, _ = s.TTL(ctx, "test")
+    if ttl != time.Minute {
+        t.Errorf("expected TTL of 1m, got %s", ttl)
+    }
+}
+
+func TestStorage_IncrementWithLimit(t *testing.T) {
+    s, server := newTestStorage(t)
+    ctx := context.Background()
+
+    count, ttl, allowed, err := s.IncrementWithLimit(ctx, "test", 4, 5, time.Minute)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if count != 4 || !allowed || ttl != time.Minute {
+        t.Errorf("expected (4, 1m, true), got (%d, %s, %v)", count, ttl, allowed)
+    }
+
+    server.FastForward(10 * time.Second)
+
+    count, ttl, allowed, _ = s.IncrementWithLimit(ctx, "test", 2, 5, time.Minute)
+    if count != 4 || allowed || ttl != 50*time.Second {
+        t.Errorf("expected (4, 50s, false), got (%d, %s, %v)", count, ttl, allowed)
+    }
+
+    count, _, allowed, _ = s.IncrementWithLimit(ctx, "test", 1, 5, time.Minute)
+    if count != 5 || !allowed {
+        t.Errorf("expected (5, true), got (%d, %v)", count, allowed)
+    }
+
+    count, _, allowed, _ = s.IncrementWithLimit(ctx, "missing", 6, 5, time.Minute)
+    if count != 0 || allowed {
+        t.Errorf("expected oversized increment to be rejected, got (%d, %v)", count, allowed)
+    }
+    if server.Exists("missing") {
+        t.Errorf("expected rejected increment not to create the key")
+    }
+}
+
+func TestStorage_SortedSet(t *testing.T) {
+    s, server := newTestStorage(t)
+    ctx := context.Background()
+
+    if err := s.AddMembers(ctx, "log", 10, time.Minute, "a", "b"); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    s.AddMembers(ctx, "log", 20, time.Minute, "c")
+    s.AddMembers(ctx, "log", 30, time.Minute, "d")
+
+    count, err := s.CountMembers(ctx, "log", 15, math.MaxInt32)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if count != 2 {
+        t.Errorf("expected 2 members with score >= 15, got %d", count)
+    }
+
+    scores, err := s.Scores(ctx, "log", 0, math.MaxInt32, 3)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if want := []int64{10, 10, 20}; !reflect.DeepEqual(scores, want) {
+        t.Errorf("expected scores %v, got %v", want, scores)
+    }
+
+    removed, err := s.RemoveMembersByScore(ctx, "log", 0, 10)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if removed != 2 {
+        t.Errorf("expected 2 members removed, got %d", removed)
+    }
+
+    s.AddMembers(ctx, "log", 40, time.Minute, "e", "f")
+    removed, err = s.RemoveLastMembers(ctx, "log", 2)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if removed != 2 {
+        t.Errorf("expected 2 members removed, got %d", removed)
+    }
+
+    if err := s.RemoveMembers(ctx, "log", "d", "missing"); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    count, _ = s.CountMembers(ctx, "log", 0, math.MaxInt32)
+    if count != 1 {
+        t.Errorf("expected 1 member left, got %d", count)
+    }
+
+    if ttl := server.TTL("log"); ttl != time.Minute {
+        t.Errorf("expected TTL 1m, got %s", ttl)
+    }
+}
+
+func TestStorage_State(t *testing.T) {
+    s, server := newTestStorage(t)
+    ctx := context.Background()
+
+    state, err := s.GetState(ctx, "state")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if state != nil {
+        t.Errorf("expected no state for missing key, got %q", state)
+    }
+
+    swapped, err := s.CompareAndSwapState(ctx, "state", nil, []byte("a"), time.Minute)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if !swapped {
+        t.Errorf("expected swap on missing key to succeed")
+    }
+    if ttl := server.TTL("state"); ttl != time.Minute {
+        t.Errorf("expected TTL 1m, got %s", ttl)
+    }
+
+    swapped, _ = s.CompareAndSwapState(ctx, "state", nil, []byte("b"), time.Minute)
+    if swapped {
+        t.Errorf("expected swap with stale state to fail")
+    }
+
+    swapped, _ = s.CompareAndSwapState(ctx, "state", []byte("a"), []byte("b"), 0)
+    if !swapped {
+        t.Errorf("expected swap with current state to succeed")
+    }
+    state, _ = s.GetState(ctx, "state")
+    if string(state) != "b" {
+        t.Errorf("expected state %q, got %q", "b", state)
+    }
+    if ttl := server.TTL("state"); ttl != 0 {
+        t.Errorf("expected no TTL, got %s", ttl)
+    }
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+    "context"
+    "time"
+)
+
+// DefaultShards is the number of shards used by ShardedStorage when none is given.
+const DefaultShards = 32
+
+// ShardedStorage is an in-process implementation of the Storage interface that spreads keys over
+// independently locked shards, so that operations on different keys rarely contend on the same mutex.
+// It has the same semantics as InMemoryStorage.
+type ShardedStorage struct {
+    shards  []*shard
+    sweeper *sweeper
+}
+
+// NewShardedStorage creates a new ShardedStorage with the given number of shards that sweeps
+// expired keys every DefaultSweepInterval. A non-positive shard count uses DefaultShards.
+func NewShardedStorage(shards int) *ShardedStorage {
+    return NewShardedStorageWithSweepInterval(shards, DefaultSweepInterval)
+}
+
+// NewShardedStorageWithSweepInterval creates a new ShardedStorage with the given number of shards that
+// sweeps expired keys at the given interval. A non-positive interval disables the background sweeper.
+func NewShardedStorageWithSweepInterval(shards int, interval time.Duration) *ShardedStorage {
+    if shards <= 0 {
+        shards = DefaultShards
+    }
+    s := &ShardedStorage{
+        shards: make([]*shard, shards),
+    }
+    for i := range s.shards {
+        s.shards[i] = newShard()
+    }
+    s.sweeper = newSweeper(interval, s.shards)
+    return s
+}
+
+// shardFor returns the shard owning key, chosen by the FNV-1a hash of the key.
+func (s *ShardedStorage) shardFor(key string) *shard {
+    const (
+        offset32 = 2166136261
+        prime32  = 16777619
+    )
+    hash := uint32(offset32)
+    for i := 0; i < len(key); i++ {
+        hash ^= uint32(key[i])
+        hash *= prime32
+    }
+    return s.shards[hash%uint32(len(s.shards))]
+}
+
+// Close stops the background sweeper. It is safe to call Close more than once.
+func (s *ShardedStorage) Close() error {
+    return s.sweeper.Close()
+}
+
+// Increment increments the counter for a given key, creating it if it does not exist.
+func (s *ShardedStorage) Increment(ctx context.Context, key string) (int, error) {
+    return s.shardFor(key).increment(key, 1), nil
+}
+
+// IncrementBy adds n to the counter for a given key, creating it if it does not exist.
+func (s *ShardedStorage) IncrementBy(ctx context.Context, key string, n int) (int, error) {
+    return s.shardFor(key).increment(key, n), nil
+}
+
+// DecrementBy subtracts n from the counter for a given key without taking it below zero.
+// It does not create a missing key and keeps the key's TTL.
+func (s *ShardedStorage) DecrementBy(ctx context.Context, key string, n int) (int, error) {
+    return s.shardFor(key).decrement(key, n), nil
+}
+
+// IncrementWithTTL increments the counter for a given key and, if the key has no expiry yet,
+// sets its time to live to ttl. Both steps are applied atomically.
+func (s *ShardedStorage) IncrementWithTTL(ctx context.Context, key string, ttl time.Duration) (int, error) {
+    return s.shardFor(key).incrementWithTTL(key, 1, ttl), nil
+}
+
+// IncrementByAndRenew adds n to the counter for a given key and sets its time to live to ttl, replacing any
+// expiry the key already had. Both steps are applied atomically.
+func (s *ShardedStorage) IncrementByAndRenew(ctx context.Context, key string, n int, ttl time.Duration) (int, error) {
+    return s.shardFor(key).incrementAndRenew(key, n, ttl), nil
+}
+
+// IncrementWithLimit adds n to the counter for a given key unless the result would exceed limit, and sets the
+// time to live of a key without an expiry to ttl. Both steps are applied atomically.
+func (s *ShardedStorage) IncrementWithLimit(ctx context.Context, key string, n, limit int, ttl time.Duration) (int, time.Duration, bool, error) {
+    count, remaining, ok := s.shardFor(key).incrementWithLimit(key, n, limit, ttl)
+    return count, remaining, ok, nil
+}
+
+// Reset removes the counter and TTL for a given key.
+func (s *ShardedStorage) Reset(ctx context.Context, key string) error {
+    s.shardFor(key).reset(key)
+    return nil
+}
+
+// TTL returns the remaining time to live for a given key.
+// It returns zero if the key does not exist or has no expiry.
+func (s *ShardedStorage) TTL(ctx context.Context, key string) (time.Duration, error) {
+    return s.shardFor(key).ttl(key), nil
+}
+
+// SetTTL sets the time to live for a given key. It has no effect if the key does not exist.
+// A non-positive ttl expires the key immediately.
+func (s *ShardedStorage) SetTTL(ctx context.Context, key string, ttl time.Duration) error {
+    s.shardFor(key).setTTL(key, ttl)
+    return nil
+}
+
+// Get returns the counter for a given key, or zero if it does not exist.
+func (s *ShardedStorage) Get(ctx context.Context, key string) (int, error) {
+    return s.shardFor(key).get(key), nil
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+    "context"
+    "fmt"
+    "sync"
+    "testing"
+    "time"
+)
+
+var (
+    _ Storage             = (*ShardedStorage)(nil)
+    _ AtomicIncrementer   = (*ShardedStorage)(nil)
+    _ LimitedIncrementer  = (*ShardedStorage)(nil)
+    _ RenewingIncrementer = (*ShardedStorage)(nil)
+)
+
+func TestShardedStorage(t *testing.T) {
+    s := NewShardedStorageWithSweepInterval(4, 0)
+    defer s.Close()
+
+    ctx := context.Background()
+    for i := 0; i < 100; i++ {
+        key := fmt.Sprintf("key-%d", i)
+        for j := 0; j <= i%3; j++ {
+            s.Increment(ctx, key)
+        }
+    }
+    for i := 0; i < 100; i++ {
+        key := fmt.Sprintf("key-%d", i)
+        count, err := s.Get(ctx, key)
+        if err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+        if count != i%3+1 {
+            t.Errorf("expected count %d for %s, got %d", i%3+1, key, count)
+        }
+    }
+
+    s.SetTTL(ctx, "key-1", 10*time.Millisecond)
+    s.Reset(ctx, "key-2")
+    time.Sleep(20 * time.Millisecond)
+
+    for _, key := range []string{"key-1", "key-2"} {
+        count, _ := s.Get(ctx, key)
+        if count != 0 {
+            t.Errorf("expected %s to be gone, got %d", key, count)
+        }
+    }
+}
+
+func TestShardedStorage_DefaultShards(t *testing.T) {
+    s := NewShardedStorage(0)
+    defer s.Close()
+
+    if len(s.shards) != DefaultShards {
+        t.Errorf("expected %d shards, got %d", DefaultShards, len(s.shards))
+    }
+}
+
+// benchmarkIncrement runs b.N increments spread over the given number of goroutines and a fixed set of keys.
+func benchmarkIncrement(b *testing.B, s Storage, goroutines int) {
+    keys := make([]string, 1024)
+    for i := range keys {
+        keys[i] = fmt.Sprintf("key-%d", i)
+    }
+
+    ctx := context.Background()
+    b.ResetTimer()
+
+    var wg sync.WaitGroup
+    for g := 0; g < goroutines; g++ {
+        n := b.N / goroutines
+        if g < b.N%goroutines {
+            n++
+        }
+        wg.Add(1)
+        go func(g, n int) {
+            defer wg.Done()
+            for i := 0; i < n; i++ {
+                s.Increment(ctx, keys[(g*n+i)%len(keys)])
+            }
+        }(g, n)
+    }
+    wg.Wait()
+}
+
+func BenchmarkInMemoryStorage_Increment(b *testing.B) {
+    for _, goroutines := range []int{1, 8, 64} {
+        b.Run(fmt.Sprintf("goroutines-%d", goroutines), func(b *testing.B) {
+            s := NewInMemoryStorage()
+            defer s.Close()
+            benchmarkIncrement(b, s, goroutines)
+        })
+    }
+}
+
+func BenchmarkShardedStorage_Increment(b *testing.B) {
+    for _, goroutines := range []int{1, 8, 64} {
+        b.Run(fmt.Sprintf("goroutines-%d", goroutines), func(b *testing.B) {
+            s := NewShardedStorage(DefaultShards)
+            defer s.Close()
+            benchmarkIncrement(b, s, goroutines)
+        })
+    }
+}
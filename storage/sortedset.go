@@ -0,0 +1,209 @@
+package storage
+
+import (
+    "context"
+    "sort"
+    "time"
+)
+
+// SortedSetStorage is implemented by storages that can keep, per key, a set of unique members ordered by an
+// integer score, such as Redis sorted sets. Redis stores scores as doubles, so they should stay within ±2^53,
+// for example Unix timestamps in microseconds.
+type SortedSetStorage interface {
+    Storage
+    // AddMembers adds members with the given score to the set stored at key and sets the key's time to live to ttl.
+    AddMembers(ctx context.Context, key string, score int64, ttl time.Duration, members ...string) error
+    // RemoveMembers removes members from the set stored at key.
+    RemoveMembers(ctx context.Context, key string, members ...string) error
+    // RemoveLastMembers removes the n members with the highest scores and returns how many were removed.
+    RemoveLastMembers(ctx context.Context, key string, n int) (int, error)
+    // RemoveMembersByScore removes the members whose score lies within [min, max] and returns how many were removed.
+    RemoveMembersByScore(ctx context.Context, key string, min, max int64) (int, error)
+    // CountMembers returns the number of members whose score lies within [min, max].
+    CountMembers(ctx context.Context, key string, min, max int64) (int, error)
+    // Scores returns, in ascending order, the scores of at most limit members whose score lies within [min, max].
+    Scores(ctx context.Context, key string, min, max int64, limit int) ([]int64, error)
+}
+
+func (sh *shard) addMembers(key string, score int64, ttl time.Duration, members []string) {
+    sh.mu.Lock()
+    defer sh.mu.Unlock()
+
+    now := time.Now()
+    e := sh.lookup(key, now)
+    if e == nil {
+        e = &entry{}
+        sh.data[key] = e
+    }
+    if e.members == nil {
+        e.members = make(map[string]int64, len(members))
+    }
+    for _, member := range members {
+        e.members[member] = score
+    }
+    if ttl > 0 {
+        e.expiresAt = now.Add(ttl)
+    }
+}
+
+func (sh *shard) removeMembers(key string, members []string) {
+    sh.mu.Lock()
+    defer sh.mu.Unlock()
+
+    e := sh.lookup(key, time.Now())
+    if e == nil {
+        return
+    }
+    for _, member := range members {
+        delete(e.members, member)
+    }
+}
+
+func (sh *shard) removeLastMembers(key string, n int) int {
+    sh.mu.Lock()
+    defer sh.mu.Unlock()
+
+    e := sh.lookup(key, time.Now())
+    if e == nil || n <= 0 {
+        return 0
+    }
+    members := make([]string, 0, len(e.members))
+    for member := range e.members {
+        members = append(members, member)
+    }
+    // Order like Redis: by score, then lexicographically
+    sort.Slice(members, func(i, j int) bool {
+        si, sj := e.members[members[i]], e.members[members[j]]
+        if si != sj {
+            return si > sj
+        }
+        return members[i] > members[j]
+    })
+    if n > len(members) {
+        n = len(members)
+    }
+    for _, member := range members[:n] {
+        delete(e.members, member)
+    }
+    return n
+}
+
+func (sh *shard) removeMembersByScore(key string, min, max int64) int {
+    sh.mu.Lock()
+    defer sh.mu.Unlock()
+
+    e := sh.lookup(key, time.Now())
+    if e == nil {
+        return 0
+    }
+    removed := 0
+    for member, score := range e.members {
+        if score >= min && score <= max {
+            delete(e.members, member)
+            removed++
+        }
+    }
+    return removed
+}
+
+func (sh *shard) countMembers(key string, min, max int64) int {
+    sh.mu.Lock()
+    defer sh.mu.Unlock()
+
+    e := sh.lookup(key, time.Now())
+    if e == nil {
+        return 0
+    }
+    count := 0
+    for _, score := range e.members {
+        if score >= min && score <= max {
+            count++
+        }
+    }
+    return count
+}
+
+func (sh *shard) scores(key string, min, max int64, limit int) []int64 {
+    sh.mu.Lock()
+    defer sh.mu.Unlock()
+
+    e := sh.lookup(key, time.Now())
+    if e == nil {
+        return nil
+    }
+    var scores []int64
+    for _, score := range e.members {
+        if score >= min && score <= max {
+            scores = append(scores, score)
+        }
+    }
+    sort.Slice(scores, func(i, j int) bool { return scores[i] < scores[j] })
+    if limit >= 0 && len(scores) > limit {
+        scores = scores[:limit]
+    }
+    return scores
+}
+
+// AddMembers adds members with the given score to the set stored at key and sets the key's time to live to ttl.
+func (s *InMemoryStorage) AddMembers(ctx context.Context, key string, score int64, ttl time.Duration, members ...string) error {
+    s.shard.addMembers(key, score, ttl, members)
+    return nil
+}
+
+// RemoveMembers removes members from the set stored at key.
+func (s *InMemoryStorage) RemoveMembers(ctx context.Context, key string, members ...string) error {
+    s.shard.removeMembers(key, members)
+    return nil
+}
+
+// RemoveLastMembers removes the n members with the highest scores and returns how many were removed.
+func (s *InMemoryStorage) RemoveLastMembers(ctx context.Context, key string, n int) (int, error) {
+    return s.shard.removeLastMembers(key, n), nil
+}
+
+// RemoveMembersByScore removes the members whose score lies within [min, max] and returns how many were removed.
+func (s *InMemoryStorage) RemoveMembersByScore(ctx context.Context, key string, min, max int64) (int, error) {
+    return s.shard.removeMembersByScore(key, min, max), nil
+}
+
+// CountMembers returns the number of members whose score lies within [min, max].
+func (s *InMemoryStorage) CountMembers(ctx context.Context, key string, min, max int64) (int, error) {
+    return s.shard.countMembers(key, min, max), nil
+}
+
+// Scores returns, in ascending order, the scores of at most limit members whose score lies within [min, max].
+func (s *InMemoryStorage) Scores(ctx context.Context, key string, min, max int64, limit int) ([]int64, error) {
+    return s.shard.scores(key, min, max, limit), nil
+}
+
+// AddMembers adds members with the given score to the set stored at key and sets the key's time to live to ttl.
+func (s *ShardedStorage) AddMembers(ctx context.Context, key string, score int64, ttl time.Duration, members ...string) error {
+    s.shardFor(key).addMembers(key, score, ttl, members)
+    return nil
+}
+
+// RemoveMembers removes members from the set stored at key.
+func (s *ShardedStorage) RemoveMembers(ctx context.Context, key string, members ...string) error {
+    s.shardFor(key).removeMembers(key, members)
+    return nil
+}
+
+// RemoveLastMembers removes the n members with the highest scores and returns how many were removed.
+func (s *ShardedStorage) RemoveLastMembers(ctx context.Context, key string, n int) (int, error) {
+    return s.shardFor(key).removeLastMembers(key, n), nil
+}
+
+// RemoveMembersByScore removes the members whose score lies within [min, max] and returns how many were removed.
+func (s *ShardedStorage) RemoveMembersByScore(ctx context.Context, key string, min, max int64) (int, error) {
+    return s.shardFor(key).removeMembersByScore(key, min, max), nil
+}
+
+// CountMembers returns the number of members whose score lies within [min, max].
+func (s *ShardedStorage) CountMembers(ctx context.Context, key string, min, max int64) (int, error) {
+    return s.shardFor(key).countMembers(key, min, max), nil
+}
+
+// Scores returns, in ascending order, the scores of at most limit members whose score lies within [min, max].
+func (s *ShardedStorage) Scores(ctx context.Context, key string, min, max int64, limit int) ([]int64, error) {
+    return s.shardFor(key).scores(key, min, max, limit), nil
+}
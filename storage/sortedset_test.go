@@ -0,0 +1,91 @@
+package storage
+
+import (
+    "context"
+    "math"
+    "reflect"
+    "testing"
+    "time"
+)
+
+var (
+    _ SortedSetStorage = (*InMemoryStorage)(nil)
+    _ SortedSetStorage = (*ShardedStorage)(nil)
+)
+
+func TestSortedSetStorage(t *testing.T) {
+    backends := []struct {
+        name    string
+        storage interface {
+            SortedSetStorage
+            Close() error
+        }
+    }{
+        {"memory", NewInMemoryStorageWithSweepInterval(0)},
+        {"sharded", NewShardedStorageWithSweepInterval(4, 0)},
+    }
+
+    ctx := context.Background()
+    for _, backend := range backends {
+        t.Run(backend.name, func(t *testing.T) {
+            s := backend.storage
+            defer s.Close()
+
+            s.AddMembers(ctx, "log", 10, time.Minute, "a", "b")
+            s.AddMembers(ctx, "log", 20, time.Minute, "c")
+            s.AddMembers(ctx, "log", 30, time.Minute, "d")
+
+            count, err := s.CountMembers(ctx, "log", 15, math.MaxInt64)
+            if err != nil {
+                t.Fatalf("unexpected error: %v", err)
+            }
+            if count != 2 {
+                t.Errorf("expected 2 members with score >= 15, got %d", count)
+            }
+
+            scores, err := s.Scores(ctx, "log", math.MinInt64, math.MaxInt64, 3)
+            if err != nil {
+                t.Fatalf("unexpected error: %v", err)
+            }
+            if want := []int64{10, 10, 20}; !reflect.DeepEqual(scores, want) {
+                t.Errorf("expected scores %v, got %v", want, scores)
+            }
+
+            removed, err := s.RemoveMembersByScore(ctx, "log", math.MinInt64, 10)
+            if err != nil {
+                t.Fatalf("unexpected error: %v", err)
+            }
+            if removed != 2 {
+                t.Errorf("expected 2 members removed, got %d", removed)
+            }
+
+            s.AddMembers(ctx, "log", 40, time.Minute, "e", "f")
+            removed, err = s.RemoveLastMembers(ctx, "log", 2)
+            if err != nil {
+                t.Fatalf("unexpected error: %v", err)
+            }
+            if removed != 2 {
+                t.Errorf("expected 2 members removed, got %d", removed)
+            }
+
+            if err := s.RemoveMembers(ctx, "log", "d", "missing"); err != nil {
+                t.Fatalf("unexpected error: %v", err)
+            }
+            count, _ = s.CountMembers(ctx, "log", math.MinInt64, math.MaxInt64)
+            if count != 1 {
+                t.Errorf("expected 1 member left, got %d", count)
+            }
+
+            ttl, _ := s.TTL(ctx, "log")
+            if ttl <= 0 || ttl > time.Minute {
+                t.Errorf("expected TTL in (0, 1m], got %s", ttl)
+            }
+
+            s.Reset(ctx, "log")
+            count, _ = s.CountMembers(ctx, "log", math.MinInt64, math.MaxInt64)
+            if count != 0 {
+                t.Errorf("expected no members after reset, got %d", count)
+            }
+        })
+    }
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+    "bytes"
+    "context"
+    "time"
+)
+
+// StateStorage is implemented by storages that can hold an opaque state value per key and replace it atomically,
+// for algorithms whose per-key state does not fit in a single counter.
+type StateStorage interface {
+    Storage
+    // GetState returns the state stored for key, or nil if there is none.
+    GetState(ctx context.Context, key string) ([]byte, error)
+    // CompareAndSwapState replaces the state stored for key with new if the current state equals old, and sets
+    // the key's time to live to ttl. An empty old matches a key without state, and a non-positive ttl means the
+    // key does not expire. It reports whether the state was replaced.
+    CompareAndSwapState(ctx context.Context, key string, old, new []byte, ttl time.Duration) (bool, error)
+}
+
+// maxStateRetries is the number of times UpdateState retries after losing a compare-and-swap race.
+const maxStateRetries = 16
+
+// UpdateState applies fn to the state stored for key and writes the result back with CompareAndSwapState,
+// retrying when another caller changes the state concurrently. fn receives the current state, or nil if there
+// is none, and returns the new state and its time to live. If fn returns a nil state, nothing is written.
+// It returns ErrConflict if the state kept changing.
+func UpdateState(ctx context.Context, s StateStorage, key string, fn func(state []byte) ([]byte, time.Duration, error)) error {
+    for i := 0; i < maxStateRetries; i++ {
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+
+        state, err := s.GetState(ctx, key)
+        if err != nil {
+            return err
+        }
+
+        next, ttl, err := fn(state)
+        if err != nil {
+            return err
+        }
+        if next == nil {
+            return nil
+        }
+
+        swapped, err := s.CompareAndSwapState(ctx, key, state, next, ttl)
+        if err != nil {
+            return err
+        }
+        if swapped {
+            return nil
+        }
+    }
+    return ErrConflict
+}
+
+func (sh *shard) getState(key string) []byte {
+    sh.mu.Lock()
+    defer sh.mu.Unlock()
+
+    e := sh.lookup(key, time.Now())
+    if e == nil || e.state == nil {
+        return nil
+    }
+    return append([]byte(nil), e.state...)
+}
+
+func (sh *shard) compareAndSwapState(key string, old, new []byte, ttl time.Duration) bool {
+    sh.mu.Lock()
+    defer sh.mu.Unlock()
+
+    now := time.Now()
+    e := sh.lookup(key, now)
+    var current []byte
+    if e != nil {
+        current = e.state
+    }
+    if !bytes.Equal(current, old) {
+        return false
+    }
+
+    if e == nil {
+        e = &entry{}
+        sh.data[key] = e
+    }
+    e.state = append([]byte(nil), new...)
+    e.expiresAt = time.Time{}
+    if ttl > 0 {
+        e.expiresAt = now.Add(ttl)
+    }
+    return true
+}
+
+// GetState returns the state stored for key, or nil if there is none.
+func (s *InMemoryStorage) GetState(ctx context.Context, key string) ([]byte, error) {
+    return s.shard.getState(key), nil
+}
+
+// CompareAndSwapState replaces the state stored for key with new if the current state equals old,
+// and sets the key's time to live to ttl.
+func (s *InMemoryStorage) CompareAndSwapState(ctx context.Context, key string, old, new []byte, ttl time.Duration) (bool, error) {
+    return s.shard.compareAndSwapState(key, old, new, ttl), nil
+}
+
+// GetState returns the state stored for key, or nil if there is none.
+func (s *ShardedStorage) GetState(ctx context.Context, key string) ([]byte, error) {
+    return s.shardFor(key).getState(key), nil
+}
+
+// CompareAndSwapState replaces the state stored for key with new if the current state equals old,
+// and sets the key's time to live to ttl.
+func (s *ShardedStorage) CompareAndSwapState(ctx context.Context, key string, old, new []byte, ttl time.Duration) (bool, error) {
+    return s.shardFor(key).compareAndSwapState(key, old, new, ttl), nil
+}
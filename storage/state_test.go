@@ -0,0 +1,121 @@
+package storage
+
+import (
+    "context"
+    "errors"
+    "strconv"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+var (
+    _ StateStorage = (*InMemoryStorage)(nil)
+    _ StateStorage = (*ShardedStorage)(nil)
+)
+
+func TestStateStorage(t *testing.T) {
+    backends := []struct {
+        name    string
+        storage interface {
+            StateStorage
+            Close() error
+        }
+    }{
+        {"memory", NewInMemoryStorageWithSweepInterval(0)},
+        {"sharded", NewShardedStorageWithSweepInterval(4, 0)},
+    }
+
+    ctx := context.Background()
+    for _, backend := range backends {
+        t.Run(backend.name, func(t *testing.T) {
+            s := backend.storage
+            defer s.Close()
+
+            state, err := s.GetState(ctx, "state")
+            if err != nil {
+                t.Fatalf("unexpected error: %v", err)
+            }
+            if state != nil {
+                t.Errorf("expected no state for missing key, got %q", state)
+            }
+
+            swapped, err := s.CompareAndSwapState(ctx, "state", nil, []byte("a"), time.Minute)
+            if err != nil {
+                t.Fatalf("unexpected error: %v", err)
+            }
+            if !swapped {
+                t.Errorf("expected swap on missing key to succeed")
+            }
+
+            swapped, _ = s.CompareAndSwapState(ctx, "state", nil, []byte("b"), time.Minute)
+            if swapped {
+                t.Errorf("expected swap with stale state to fail")
+            }
+
+            swapped, _ = s.CompareAndSwapState(ctx, "state", []byte("a"), []byte("b"), 10*time.Millisecond)
+            if !swapped {
+                t.Errorf("expected swap with current state to succeed")
+            }
+            state, _ = s.GetState(ctx, "state")
+            if string(state) != "b" {
+                t.Errorf("expected state %q, got %q", "b", state)
+            }
+
+            time.Sleep(20 * time.Millisecond)
+            state, _ = s.GetState(ctx, "state")
+            if state != nil {
+                t.Errorf("expected state to expire, got %q", state)
+            }
+        })
+    }
+}
+
+func TestUpdateState(t *testing.T) {
+    s := NewInMemoryStorageWithSweepInterval(0)
+    defer s.Close()
+
+    ctx := context.Background()
+    increment := func(state []byte) ([]byte, time.Duration, error) {
+        n, _ := strconv.Atoi(string(state))
+        return []byte(strconv.Itoa(n + 1)), time.Minute, nil
+    }
+
+    const goroutines, updates = 4, 25
+    var (
+        wg      sync.WaitGroup
+        applied int64
+    )
+    for g := 0; g < goroutines; g++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := 0; i < updates; i++ {
+                err := UpdateState(ctx, s, "state", increment)
+                switch {
+                case err == nil:
+                    atomic.AddInt64(&applied, 1)
+                case !errors.Is(err, ErrConflict):
+                    t.Errorf("unexpected error: %v", err)
+                }
+            }
+        }()
+    }
+    wg.Wait()
+
+    state, _ := s.GetState(ctx, "state")
+    if n, _ := strconv.Atoi(string(state)); int64(n) != applied {
+        t.Errorf("expected %d applied updates, got %d", applied, n)
+    }
+
+    err := UpdateState(ctx, s, "state", func(state []byte) ([]byte, time.Duration, error) {
+        return nil, 0, nil
+    })
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if after, _ := s.GetState(ctx, "state"); string(after) != string(state) {
+        t.Errorf("expected nil state to leave %q unchanged, got %q", state, after)
+    }
+}
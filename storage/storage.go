@@ -2,14 +2,133 @@ package storage
 
 import (
     "context"
+    "errors"
     "time"
 )
 
+// ErrConflict is returned when an update could not be applied because the key kept being modified concurrently.
+var ErrConflict = errors.New("storage: too many concurrent updates")
+
 // Storage is the interface for rate limit storage.
 type Storage interface {
     Increment(ctx context.Context, key string) (int, error)
+    IncrementBy(ctx context.Context, key string, n int) (int, error)
+    // DecrementBy subtracts n from the counter for a given key without taking it below zero, and returns the new
+    // value. It does not create a missing key and keeps the key's TTL.
+    DecrementBy(ctx context.Context, key string, n int) (int, error)
     Reset(ctx context.Context, key string) error
     TTL(ctx context.Context, key string) (time.Duration, error)
     SetTTL(ctx context.Context, key string, ttl time.Duration) error
     Get(ctx context.Context, key string) (int, error)
 }
+
+// AtomicIncrementer is implemented by storages that can increment a counter and set its expiry in a single atomic operation.
+type AtomicIncrementer interface {
+    // IncrementWithTTL increments the counter for a given key and, if the key has no expiry yet, sets its time to live to ttl.
+    IncrementWithTTL(ctx context.Context, key string, ttl time.Duration) (int, error)
+}
+
+// IncrementWithTTL increments the counter for a given key and makes sure a new key expires after ttl.
+// It uses a single atomic call when s implements AtomicIncrementer, and otherwise falls back to Increment
+// followed by SetTTL when the increment created the key.
+func IncrementWithTTL(ctx context.Context, s Storage, key string, ttl time.Duration) (int, error) {
+    if ai, ok := s.(AtomicIncrementer); ok {
+        return ai.IncrementWithTTL(ctx, key, ttl)
+    }
+
+    count, err := s.Increment(ctx, key)
+    if err != nil {
+        return 0, err
+    }
+
+    if count == 1 && ttl > 0 {
+        // Set a TTL if this is the first request
+        err := s.SetTTL(ctx, key, ttl)
+        if err != nil {
+            return 0, err
+        }
+    }
+
+    return count, nil
+}
+
+// RenewingIncrementer is implemented by storages that can add to a counter and restart its expiry in a single
+// atomic operation.
+type RenewingIncrementer interface {
+    // IncrementByAndRenew adds n to the counter for a given key and sets its time to live to ttl, replacing any
+    // expiry the key already had.
+    IncrementByAndRenew(ctx context.Context, key string, n int, ttl time.Duration) (int, error)
+}
+
+// IncrementByAndRenew adds n to the counter for a given key and restarts its expiry at ttl. It uses a single atomic
+// call when s implements RenewingIncrementer, and otherwise falls back to IncrementBy followed by SetTTL, which can
+// leave the counter without an expiry if SetTTL fails.
+func IncrementByAndRenew(ctx context.Context, s Storage, key string, n int, ttl time.Duration) (int, error) {
+    if ri, ok := s.(RenewingIncrementer); ok {
+        return ri.IncrementByAndRenew(ctx, key, n, ttl)
+    }
+
+    count, err := s.IncrementBy(ctx, key, n)
+    if err != nil {
+        return 0, err
+    }
+    if err := s.SetTTL(ctx, key, ttl); err != nil {
+        return 0, err
+    }
+    return count, nil
+}
+
+// LimitedIncrementer is implemented by storages that can add to a counter only while it stays within a limit,
+// in a single atomic operation.
+type LimitedIncrementer interface {
+    // IncrementWithLimit adds n to the counter for a given key unless the result would exceed limit, in which case
+    // the counter is left unchanged. If the key has no expiry yet, its time to live is set to ttl.
+    // It returns the counter value and remaining time to live after the call, and whether n was added.
+    IncrementWithLimit(ctx context.Context, key string, n, limit int, ttl time.Duration) (int, time.Duration, bool, error)
+}
+
+// IncrementWithLimit adds n to the counter for a given key unless the result would exceed limit, and makes sure
+// a new key expires after ttl. It returns the counter value and remaining time to live after the call, and whether
+// n was added. It uses a single atomic call when s implements LimitedIncrementer. Otherwise it checks the counter
+// first and undoes the increment if a concurrent caller pushed the counter over the limit in the meantime.
+func IncrementWithLimit(ctx context.Context, s Storage, key string, n, limit int, ttl time.Duration) (int, time.Duration, bool, error) {
+    if li, ok := s.(LimitedIncrementer); ok {
+        return li.IncrementWithLimit(ctx, key, n, limit, ttl)
+    }
+
+    count, err := s.Get(ctx, key)
+    if err != nil {
+        return 0, 0, false, err
+    }
+
+    allowed := count+n <= limit
+    if allowed {
+        count, err = s.IncrementBy(ctx, key, n)
+        if err != nil {
+            return 0, 0, false, err
+        }
+
+        if count == n && ttl > 0 {
+            // Set a TTL if this call created the key
+            err := s.SetTTL(ctx, key, ttl)
+            if err != nil {
+                return 0, 0, false, err
+            }
+        }
+
+        if count > limit {
+            count, err = s.IncrementBy(ctx, key, -n)
+            if err != nil {
+                return 0, 0, false, err
+            }
+            allowed = false
+        }
+    }
+
+    remaining, err := s.TTL(ctx, key)
+    if err != nil {
+        return 0, 0, false, err
+    }
+
+    return count, remaining, allowed, nil
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+// nonAtomicStorage hides the AtomicIncrementer implementation of the wrapped storage.
+type nonAtomicStorage struct {
+    Storage
+}
+
+func TestIncrementWithTTL(t *testing.T) {
+    backend := NewInMemoryStorageWithSweepInterval(0)
+    defer backend.Close()
+
+    tests := []struct {
+        name    string
+        storage Storage
+    }{
+        {"atomic", backend},
+        {"fallback", nonAtomicStorage{backend}},
+    }
+
+    ctx := context.Background()
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            key := tt.name
+            count, err := IncrementWithTTL(ctx, tt.storage, key, time.Minute)
+            if err != nil {
+                t.Fatalf("unexpected error: %v", err)
+            }
+            if count != 1 {
+                t.Errorf("expected count 1, got %d", count)
+            }
+
+            tt.storage.SetTTL(ctx, key, time.Hour)
+            count, _ = IncrementWithTTL(ctx, tt.storage, key, time.Minute)
+            if count != 2 {
+                t.Errorf("expected count 2, got %d", count)
+            }
+
+            ttl, _ := tt.storage.TTL(ctx, key)
+            if ttl <= time.Minute {
+                t.Errorf("expected existing TTL to be kept, got %s", ttl)
+            }
+        })
+    }
+}
+
+func TestIncrementByAndRenew(t *testing.T) {
+    backend := NewInMemoryStorageWithSweepInterval(0)
+    defer backend.Close()
+
+    tests := []struct {
+        name    string
+        storage Storage
+    }{
+        {"atomic", backend},
+        {"fallback", nonAtomicStorage{backend}},
+    }
+
+    ctx := context.Background()
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            key := tt.name
+            count, err := IncrementByAndRenew(ctx, tt.storage, key, 3, time.Hour)
+            if err != nil {
+                t.Fatalf("unexpected error: %v", err)
+            }
+            if count != 3 {
+                t.Errorf("expected count 3, got %d", count)
+            }
+
+            count, _ = IncrementByAndRenew(ctx, tt.storage, key, 2, time.Minute)
+            if count != 5 {
+                t.Errorf("expected count 5, got %d", count)
+            }
+
+            ttl, _ := tt.storage.TTL(ctx, key)
+            if ttl <= 59*time.Second || ttl > time.Minute {
+                t.Errorf("expected the TTL to be restarted at 1m, got %s", ttl)
+            }
+        })
+    }
+}
+
+func TestIncrementWithLimit(t *testing.T) {
+    backend := NewInMemoryStorageWithSweepInterval(0)
+    defer backend.Close()
+
+    tests := []struct {
+        name    string
+        storage Storage
+    }{
+        {"atomic", backend},
+        {"fallback", nonAtomicStorage{backend}},
+    }
+
+    steps := []struct {
+        n       int
+        count   int
+        allowed bool
+    }{
+        {4, 4, true},
+        {2, 4, false},
+        {1, 5, true},
+        {1, 5, false},
+    }
+
+    ctx := context.Background()
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            key := tt.name
+            for i, step := range steps {
+                count, ttl, allowed, err := IncrementWithLimit(ctx, tt.storage, key, step.n, 5, time.Minute)
+                if err != nil {
+                    t.Fatalf("unexpected error: %v", err)
+                }
+                if count != step.count || allowed != step.allowed {
+                    t.Errorf("step %d: expected (%d, %v), got (%d, %v)", i+1, step.count, step.allowed, count, allowed)
+                }
+                if ttl <= 0 || ttl > time.Minute {
+                    t.Errorf("step %d: expected TTL in (0, 1m], got %s", i+1, ttl)
+                }
+            }
+        })
+    }
+
+    count, _, allowed, _ := IncrementWithLimit(ctx, backend, "missing", 6, 5, time.Minute)
+    if count != 0 || allowed {
+        t.Errorf("expected oversized increment to be rejected, got (%d, %v)", count, allowed)
+    }
+    if ttl, _ := backend.TTL(ctx, "missing"); ttl != 0 {
+        t.Errorf("expected rejected increment not to create the key, got TTL %s", ttl)
+    }
+}
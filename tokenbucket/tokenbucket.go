@@ -2,133 +2,450 @@ package tokenbucket
 
 import (
     "context"
-    "sync"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
     "time"
 
     "github.com/umbeluzi/ratelimit/config"
+    "github.com/umbeluzi/ratelimit/internal/muldiv"
+    "github.com/umbeluzi/ratelimit/limiter"
     "github.com/umbeluzi/ratelimit/storage"
 )
 
+// Name is the name of the token bucket algorithm.
+const Name = "tokenbucket"
+
+// ErrInvalidRate is returned when the configured number of requests or interval is not positive.
+var ErrInvalidRate = errors.New("tokenbucket: max requests and interval must be positive")
+
 // TokenBucket is an implementation of the token bucket rate limiting algorithm.
+// Every key has its own bucket holding up to MaxRequests + BurstLimit tokens, refilled at MaxRequests tokens per
+// Interval. The bucket's tokens and last refill time are kept in storage under the key, and refills are computed
+// lazily from the elapsed time whenever the bucket is used. A TokenBucket starts no goroutines and holds no
+// resources, so it needs no shutdown and can be created per tenant and garbage collected.
 type TokenBucket struct {
-    storage     storage.Storage
-    config      config.Config
-    mu          sync.Mutex
-    ticker      *time.Ticker
-    stopChannel chan struct{}
+    storage storage.StateStorage
+    policy  config.Policy
+    now     func() time.Time
 }
 
 // New creates a new TokenBucket rate limiter.
-func New(storage storage.Storage, config config.Config) *TokenBucket {
-    tb := &TokenBucket{
-        storage:     storage,
-        config:      config,
-        stopChannel: make(chan struct{}),
-    }
-    tb.startRefill()
-    return tb
-}
-
-// startRefill starts the refill ticker.
-func (tb *TokenBucket) startRefill() {
-    interval, _ := tb.config.Interval(context.Background())
-    tb.ticker = time.NewTicker(interval)
-    go func() {
-        for {
-            select {
-            case <-tb.ticker.C:
-                tb.mu.Lock()
-                tb.refillTokens()
-                tb.mu.Unlock()
-            case <-tb.stopChannel:
-                tb.ticker.Stop()
-                return
-            }
+func New(storage storage.StateStorage, policy config.Policy) *TokenBucket {
+    return &TokenBucket{
+        storage: storage,
+        policy:  policy,
+        now:     time.Now,
+    }
+}
+
+// Stop is a no-op kept for compatibility. Buckets are refilled lazily and no longer need a background goroutine.
+//
+// Deprecated: TokenBucket has no resources to release, so calls to Stop can be removed.
+func (tb *TokenBucket) Stop() {}
+
+// rate holds the parameters derived from the configuration.
+type rate struct {
+    maxRequests int
+    burstLimit  int
+    interval    time.Duration
+    // granted is the number of units temporarily granted to the key.
+    granted int
+}
+
+// capacity returns the number of tokens a full bucket holds.
+func (r rate) capacity() int {
+    return r.maxRequests + r.burstLimit + r.granted
+}
+
+// refillTime returns how long it takes to refill n tokens, rounded up.
+func (r rate) refillTime(n int) time.Duration {
+    if n <= 0 {
+        return 0
+    }
+    return time.Duration(muldiv.Ceil(int64(n), int64(r.interval), int64(r.maxRequests)))
+}
+
+// rate reads the configuration and the units granted to key.
+func (tb *TokenBucket) rate(ctx context.Context, key string) (rate, error) {
+    limits, err := config.LimitsFor(ctx, tb.policy, key)
+    if err != nil {
+        return rate{}, err
+    }
+    maxRequests, interval, burstLimit := limits.Requests, limits.Period, limits.Burst
+
+    if maxRequests <= 0 || interval <= 0 {
+        return rate{}, ErrInvalidRate
+    }
+
+    granted, err := limiter.Granted(ctx, tb.storage, key)
+    if err != nil {
+        return rate{}, err
+    }
+
+    return rate{maxRequests: maxRequests, burstLimit: burstLimit, interval: interval, granted: granted}, nil
+}
+
+// bucket is the state stored for each key.
+type bucket struct {
+    tokens     int
+    lastRefill time.Time
+}
+
+// decode parses a bucket stored as "<tokens>:<last refill in Unix nanoseconds>".
+func decode(state []byte) (bucket, error) {
+    tokens, lastRefill, ok := strings.Cut(string(state), ":")
+    if !ok {
+        return bucket{}, fmt.Errorf("tokenbucket: malformed state %q", state)
+    }
+    b := bucket{}
+    var err error
+    b.tokens, err = strconv.Atoi(tokens)
+    if err != nil {
+        return bucket{}, fmt.Errorf("tokenbucket: malformed state %q: %w", state, err)
+    }
+    nanos, err := strconv.ParseInt(lastRefill, 10, 64)
+    if err != nil {
+        return bucket{}, fmt.Errorf("tokenbucket: malformed state %q: %w", state, err)
+    }
+    b.lastRefill = time.Unix(0, nanos)
+    return b, nil
+}
+
+// encode serializes the bucket for decode.
+func (b bucket) encode() []byte {
+    return []byte(strconv.Itoa(b.tokens) + ":" + strconv.FormatInt(b.lastRefill.UnixNano(), 10))
+}
+
+// refill returns the bucket as of now. A missing state is a full bucket. Whole tokens are added for the time
+// elapsed since the last refill, and the last refill time only advances by the time those tokens account for,
+// so partial progress towards the next token is kept.
+func (r rate) refill(state []byte, now time.Time) (bucket, error) {
+    if state == nil {
+        return bucket{tokens: r.capacity(), lastRefill: now}, nil
+    }
+    b, err := decode(state)
+    if err != nil {
+        return bucket{}, err
+    }
+
+    elapsed := now.Sub(b.lastRefill)
+    if elapsed <= 0 {
+        return b, nil
+    }
+    if elapsed >= r.refillTime(r.capacity()-b.tokens) {
+        return bucket{tokens: r.capacity(), lastRefill: now}, nil
+    }
+
+    added := int(muldiv.Floor(int64(elapsed), int64(r.maxRequests), int64(r.interval)))
+    b.tokens += added
+    b.lastRefill = b.lastRefill.Add(time.Duration(muldiv.Floor(int64(added), int64(r.interval), int64(r.maxRequests))))
+    return b, nil
+}
+
+// remaining returns the number of tokens the bucket can hand out, which is zero while it is in debt.
+func (b bucket) remaining() int {
+    if b.tokens < 0 {
+        return 0
+    }
+    return b.tokens
+}
+
+// availableAt returns the time at which the bucket holds at least n tokens.
+func (r rate) availableAt(b bucket, n int) time.Time {
+    if b.tokens >= n {
+        return b.lastRefill
+    }
+    return b.lastRefill.Add(r.refillTime(n - b.tokens))
+}
+
+// decide evaluates a request costing n tokens against the bucket as of now. It returns the result and the bucket
+// with the tokens taken if the request is allowed.
+func (r rate) decide(b bucket, now time.Time, n int) (limiter.Result, bucket) {
+    if b.tokens < n {
+        result := limiter.Result{
+            Allowed:    false,
+            Limit:      r.capacity(),
+            Remaining:  b.remaining(),
+            ResetAt:    r.availableAt(b, r.capacity()),
+            RetryAfter: r.availableAt(b, n).Sub(now),
         }
-    }()
-}
-
-// refillTokens refills the bucket with tokens at the defined refill rate.
-func (tb *TokenBucket) refillTokens() {
-    interval, err := tb.config.Interval(context.Background())
-    if err == nil {
-        now := time.Now()
-        lastRefill, _ := tb.config.LastRefill(context.Background())
-        elapsed := now.Sub(lastRefill)
-        tokensToAdd := int(elapsed / interval)
-        tokens, _ := tb.config.Tokens(context.Background())
-        tokens += tokensToAdd
-        maxTokens, _ := tb.config.MaxRequests(context.Background())
-        if tokens > maxTokens {
-            tokens = maxTokens
+        if n > r.capacity() {
+            // The request does not fit even into a full bucket
+            result.RetryAfter = r.interval
         }
-        tb.config.SetTokens(context.Background(), tokens)
-        tb.config.SetLastRefill(context.Background(), now)
+        return result, b
     }
-}
 
-// Stop stops the refill ticker for graceful shutdown.
-func (tb *TokenBucket) Stop() {
-    close(tb.stopChannel)
+    b.tokens -= n
+    return limiter.Result{
+        Allowed:   true,
+        Limit:     r.capacity(),
+        Remaining: b.remaining(),
+        ResetAt:   r.availableAt(b, r.capacity()),
+    }, b
 }
 
 // Allow checks if a request is allowed for a given key using the token bucket algorithm.
 func (tb *TokenBucket) Allow(ctx context.Context, key string) (bool, error) {
-    tb.mu.Lock()
-    defer tb.mu.Unlock()
+    return tb.AllowN(ctx, key, 1)
+}
 
-    tokens, err := tb.config.Tokens(ctx)
+// AllowN checks if a request costing n tokens is allowed for a given key using the token bucket algorithm.
+// If the key's bucket holds fewer than n tokens, the request is denied and nothing is consumed.
+func (tb *TokenBucket) AllowN(ctx context.Context, key string, n int) (bool, error) {
+    result, err := tb.AllowResult(ctx, key, n)
     if err != nil {
         return false, err
     }
+    return result.Allowed, nil
+}
+
+// Wait blocks until a request is allowed for a given key using the token bucket algorithm, or ctx is done.
+func (tb *TokenBucket) Wait(ctx context.Context, key string) error {
+    return tb.WaitN(ctx, key, 1)
+}
 
-    burstLimit, err := tb.config.BurstLimit(ctx)
+// WaitN blocks until a request costing n units is allowed for a given key using the token bucket algorithm, or ctx is
+// done. It fails immediately if n exceeds the limit or the request would not be allowed before ctx's deadline.
+func (tb *TokenBucket) WaitN(ctx context.Context, key string, n int) error {
+    return limiter.Wait(ctx, n, func(ctx context.Context) (limiter.Result, error) {
+        return tb.AllowResult(ctx, key, n)
+    })
+}
+
+// AllowResult is like AllowN but reports the full outcome of the decision. The bucket is updated with a
+// compare-and-swap, so concurrent callers sharing a storage never take more tokens than the bucket holds.
+// A non-positive n consumes nothing and only reports the current quota.
+func (tb *TokenBucket) AllowResult(ctx context.Context, key string, n int) (limiter.Result, error) {
+    if n < 0 {
+        n = 0
+    }
+
+    r, err := tb.rate(ctx, key)
     if err != nil {
-        return false, err
+        return limiter.Result{}, err
     }
 
-    if tokens > 0 {
-        tb.config.SetTokens(ctx, tokens-1)
-        return true, nil
+    var result limiter.Result
+    err = storage.UpdateState(ctx, tb.storage, key, func(state []byte) ([]byte, time.Duration, error) {
+        now := tb.now()
+        b, err := r.refill(state, now)
+        if err != nil {
+            return nil, 0, err
+        }
+
+        result, b = r.decide(b, now, n)
+        if !result.Allowed || n == 0 {
+            return nil, 0, nil
+        }
+        // Once the bucket is full again, its state is the same as a missing one
+        return b.encode(), result.ResetAt.Sub(now), nil
+    })
+    if err != nil {
+        return limiter.Result{}, err
+    }
+
+    return result, nil
+}
+
+// Check reports the result AllowResult would return for a request costing n tokens, without taking any.
+func (tb *TokenBucket) Check(ctx context.Context, key string, n int) (limiter.Result, error) {
+    if n < 0 {
+        n = 0
     }
 
-    count, err := tb.storage.Increment(ctx, key)
+    r, err := tb.rate(ctx, key)
     if err != nil {
-        return false, err
+        return limiter.Result{}, err
+    }
+
+    now := tb.now()
+    b, err := tb.load(ctx, key, r, now)
+    if err != nil {
+        return limiter.Result{}, err
+    }
+
+    result, _ := r.decide(b, now, n)
+    return result, nil
+}
+
+// Reserve reserves n tokens for a given key. Unlike AllowN, it also reserves tokens the bucket does not hold yet:
+// the bucket goes into debt, and the reservation's Delay reports when the tokens will have been refilled.
+// The reservation is not OK only if n exceeds the bucket's capacity. Canceling it puts the tokens back.
+func (tb *TokenBucket) Reserve(ctx context.Context, key string, n int) (*limiter.Reservation, error) {
+    if n < 0 {
+        n = 0
+    }
+
+    r, err := tb.rate(ctx, key)
+    if err != nil {
+        return nil, err
+    }
+    if n > r.capacity() {
+        return limiter.NewReservation(false, tb.now().Add(r.interval), nil), nil
+    }
+
+    at, err := tb.reserve(ctx, key, r, n)
+    if err != nil {
+        return nil, err
+    }
+
+    return limiter.NewReservation(true, at, func(ctx context.Context) error {
+        return tb.release(ctx, key, r, n)
+    }), nil
+}
+
+// Refund credits back n tokens previously consumed for a given key, for requests that should not count against
+// the limit after all. The bucket never holds more than its capacity.
+func (tb *TokenBucket) Refund(ctx context.Context, key string, n int) error {
+    if n <= 0 {
+        return nil
+    }
+
+    r, err := tb.rate(ctx, key)
+    if err != nil {
+        return err
+    }
+    return tb.release(ctx, key, r, n)
+}
+
+// Charge consumes n tokens for a given key after the fact, even if the bucket does not hold them, for costs that
+// are only known once a request has been served. The bucket goes into debt, delaying later requests accordingly.
+func (tb *TokenBucket) Charge(ctx context.Context, key string, n int) error {
+    if n <= 0 {
+        return nil
+    }
+
+    r, err := tb.rate(ctx, key)
+    if err != nil {
+        return err
     }
+    _, err = tb.reserve(ctx, key, r, n)
+    return err
+}
+
+// reserve takes n tokens from the bucket stored for key, going into debt if needed, and returns the time at which
+// the bucket held the n tokens.
+func (tb *TokenBucket) reserve(ctx context.Context, key string, r rate, n int) (time.Time, error) {
+    var at time.Time
+    err := storage.UpdateState(ctx, tb.storage, key, func(state []byte) ([]byte, time.Duration, error) {
+        now := tb.now()
+        b, err := r.refill(state, now)
+        if err != nil {
+            return nil, 0, err
+        }
+
+        at = r.availableAt(b, n)
+        if n == 0 {
+            return nil, 0, nil
+        }
+        b.tokens -= n
+        return b.encode(), r.availableAt(b, r.capacity()).Sub(now), nil
+    })
+    return at, err
+}
+
+// release puts n tokens back into the bucket stored for key.
+func (tb *TokenBucket) release(ctx context.Context, key string, r rate, n int) error {
+    return storage.UpdateState(ctx, tb.storage, key, func(state []byte) ([]byte, time.Duration, error) {
+        now := tb.now()
+        b, err := r.refill(state, now)
+        if err != nil || b.tokens >= r.capacity() {
+            return nil, 0, err
+        }
+
+        b.tokens += n
+        if b.tokens > r.capacity() {
+            b.tokens = r.capacity()
+        }
+        ttl := r.availableAt(b, r.capacity()).Sub(now)
+        if ttl <= 0 {
+            // A full bucket is the same as none; let it expire right away
+            ttl = time.Nanosecond
+        }
+        return b.encode(), ttl, nil
+    })
+}
 
-    if count > burstLimit {
-        return false, nil
+// load returns the key's bucket as of now.
+func (tb *TokenBucket) load(ctx context.Context, key string, r rate, now time.Time) (bucket, error) {
+    state, err := tb.storage.GetState(ctx, key)
+    if err != nil {
+        return bucket{}, err
     }
+    return r.refill(state, now)
+}
 
-    return true, nil
+// Reset clears the usage recorded for a given key, giving it its full quota back. Quota granted to the key is kept.
+func (tb *TokenBucket) Reset(ctx context.Context, key string) error {
+    return tb.storage.Reset(ctx, key)
 }
 
-// Quota returns the current quota information.
-func (tb *TokenBucket) Quota(ctx context.Context, key string) (int, int, int, error) {
-    count, err := tb.storage.Get(ctx, key)
+// Grant raises the capacity of a given key's bucket by n tokens for the given duration, on top of the configured
+// limit and burst, and adds the n tokens to the bucket right away rather than waiting for them to refill. Once the
+// grant expires, tokens beyond the configured capacity are dropped at the next refill.
+// Granting again adds to the current grant and restarts its expiry. See limiter.Grant.
+func (tb *TokenBucket) Grant(ctx context.Context, key string, n int, ttl time.Duration) error {
+    if n <= 0 || ttl <= 0 {
+        return nil
+    }
+    if err := limiter.Grant(ctx, tb.storage, key, n, ttl); err != nil {
+        return err
+    }
+
+    r, err := tb.rate(ctx, key)
     if err != nil {
-        return 0, 0, 0, err
+        return err
     }
+    return tb.release(ctx, key, r, n)
+}
 
-    maxRequests, err := tb.config.MaxRequests(ctx)
+// Revoke withdraws any tokens granted to a given key before the grant expires.
+func (tb *TokenBucket) Revoke(ctx context.Context, key string) error {
+    return limiter.Revoke(ctx, tb.storage, key)
+}
+
+// Quota returns the current quota information. The window runs from the bucket's last refill until it is full again.
+func (tb *TokenBucket) Quota(ctx context.Context, key string) (limiter.Quota, error) {
+    r, err := tb.rate(ctx, key)
     if err != nil {
-        return 0, 0, 0, err
+        return limiter.Quota{}, err
     }
 
-    burstLimit, err := tb.config.BurstLimit(ctx)
+    b, err := tb.load(ctx, key, r, tb.now())
     if err != nil {
-        return 0, 0, 0, err
+        return limiter.Quota{}, err
     }
 
-    return count, maxRequests, burstLimit, nil
+    return limiter.Quota{
+        Algorithm:   Name,
+        Used:        r.capacity() - b.tokens,
+        Limit:       r.maxRequests,
+        Burst:       r.burstLimit,
+        Granted:     r.granted,
+        Remaining:   b.remaining(),
+        WindowStart: b.lastRefill,
+        WindowEnd:   r.availableAt(b, r.capacity()),
+    }, nil
 }
 
-// NextAllowed returns the time duration until the next allowed request.
+// NextAllowed returns the time duration until the key's bucket holds a token.
 func (tb *TokenBucket) NextAllowed(ctx context.Context, key string) (time.Duration, error) {
-    ttl, err := tb.storage.TTL(ctx, key)
+    r, err := tb.rate(ctx, key)
     if err != nil {
         return 0, err
     }
-    return ttl, nil
+
+    now := tb.now()
+    b, err := tb.load(ctx, key, r, now)
+    if err != nil {
+        return 0, err
+    }
+
+    if wait := r.availableAt(b, 1).Sub(now); wait > 0 {
+        return wait, nil
+    }
+    return 0, nil
 }
@@ -2,58 +2,292 @@ package tokenbucket
 
 import (
     "context"
+    "errors"
+    "fmt"
+    "runtime"
     "testing"
     "time"
 
     "github.com/umbeluzi/ratelimit/config"
-    "github.com/umbeluzi/ratelimit/storage"
+    "github.com/umbeluzi/ratelimit/internal/clocktest"
 )
 
-type MockStorage struct {
-    count int
+func newTestLimiter(t *testing.T) *TokenBucket {
+    tb := New(clocktest.Storage(t), clocktest.Policy())
+    clocktest.Pin(&tb.now, 0)
+    return tb
 }
 
-func (ms *MockStorage) Increment(ctx context.Context, key string) (int, error) {
-    ms.count++
-    return ms.count, nil
+func TestTokenBucket_Allow(t *testing.T) {
+    tb := newTestLimiter(t)
+
+    for i := 0; i < 9; i++ {
+        allowed, err := tb.Allow(context.Background(), "test")
+        if err != nil {
+            t.Errorf("unexpected error: %v", err)
+        }
+
+        if i < 7 && !allowed {
+            t.Errorf("request %d should be allowed", i+1)
+        }
+
+        if i >= 7 && allowed {
+            t.Errorf("request %d should be denied", i+1)
+        }
+    }
 }
 
-func (ms *MockStorage) Reset(ctx context.Context, key string) error {
-    ms.count = 0
-    return nil
+func TestTokenBucket_AllowN(t *testing.T) {
+    tb := newTestLimiter(t)
+
+    steps := []struct {
+        n       int
+        allowed bool
+    }{
+        {5, true},
+        {3, false},
+        {2, true},
+        {1, false},
+    }
+
+    for i, step := range steps {
+        allowed, err := tb.AllowN(context.Background(), "test", step.n)
+        if err != nil {
+            t.Errorf("unexpected error: %v", err)
+        }
+
+        if allowed != step.allowed {
+            t.Errorf("step %d: expected allowed=%v for n=%d, got %v", i+1, step.allowed, step.n, allowed)
+        }
+    }
+
+    quota, _ := tb.Quota(context.Background(), "test")
+    if quota.Used != 7 || quota.Remaining != 0 {
+        t.Errorf("expected all tokens to be used, got %+v", quota)
+    }
 }
 
-func (ms *MockStorage) TTL(ctx context.Context, key string) (time.Duration, error) {
-    return time.Minute, nil
+func TestTokenBucket_AllowResult(t *testing.T) {
+    tb := newTestLimiter(t)
+
+    result, err := tb.AllowResult(context.Background(), "test", 5)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if !result.Allowed || result.Limit != 7 || result.Remaining != 2 || result.RetryAfter != 0 {
+        t.Errorf("unexpected result for allowed request: %+v", result)
+    }
+    // Five tokens refill at 5 per minute
+    if !result.ResetAt.Equal(clocktest.Start.Add(time.Minute)) {
+        t.Errorf("expected ResetAt after 1m, got %s", result.ResetAt)
+    }
+
+    result, err = tb.AllowResult(context.Background(), "test", 3)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if result.Allowed || result.Remaining != 2 {
+        t.Errorf("unexpected result for denied request: %+v", result)
+    }
+    if result.RetryAfter != 12*time.Second {
+        t.Errorf("expected RetryAfter until the next token, got %s", result.RetryAfter)
+    }
 }
 
-func (ms *MockStorage) SetTTL(ctx context.Context, key string, ttl time.Duration) error {
-    return nil
+func TestTokenBucket_Refill(t *testing.T) {
+    tb := newTestLimiter(t)
+    ctx := context.Background()
+
+    if allowed, _ := tb.AllowN(ctx, "test", 7); !allowed {
+        t.Fatalf("expected a full bucket to allow 7 tokens")
+    }
+
+    // One token is added every 12s; partial progress towards the next one is kept
+    clocktest.Pin(&tb.now, 18*time.Second)
+    if allowed, _ := tb.Allow(ctx, "test"); !allowed {
+        t.Errorf("expected one refilled token after 18s")
+    }
+    next, _ := tb.NextAllowed(ctx, "test")
+    if next != 6*time.Second {
+        t.Errorf("expected next token in 6s, got %s", next)
+    }
+
+    clocktest.Pin(&tb.now, 24*time.Second)
+    if allowed, _ := tb.Allow(ctx, "test"); !allowed {
+        t.Errorf("expected a second refilled token after 24s")
+    }
+
+    // The bucket never holds more than its capacity
+    clocktest.Pin(&tb.now, time.Hour)
+    quota, _ := tb.Quota(ctx, "test")
+    if quota.Used != 0 || quota.Remaining != 7 {
+        t.Errorf("expected a full bucket, got %+v", quota)
+    }
 }
 
-func (ms *MockStorage) Get(ctx context.Context, key string) (int, error) {
-    return ms.count, nil
+func TestTokenBucket_LargeLimit(t *testing.T) {
+    // A million tokens a day overflows int64 nanoseconds when multiplied naively
+    tb := New(clocktest.Storage(t), config.Limits{Requests: 1000000, Period: 24 * time.Hour})
+    clocktest.Pin(&tb.now, 0)
+    ctx := context.Background()
+
+    result, err := tb.AllowResult(ctx, "test", 1000000)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if !result.Allowed || result.ResetAt != clocktest.Start.Add(24*time.Hour) {
+        t.Errorf("expected the bucket to refill in 24h, got %+v", result)
+    }
+
+    result, _ = tb.AllowResult(ctx, "test", 10)
+    if result.Allowed || result.RetryAfter != 864*time.Millisecond {
+        t.Errorf("expected an empty bucket, got %+v", result)
+    }
+
+    clocktest.Pin(&tb.now, 12*time.Hour)
+    if quota, _ := tb.Quota(ctx, "test"); quota.Remaining != 500000 {
+        t.Errorf("expected half the bucket refilled after 12h, got %+v", quota)
+    }
 }
 
-func TestTokenBucket_Allow(t *testing.T) {
-    storage := &MockStorage{}
-    config := config.NewStatic(5, time.Minute, 2, 0, time.Now())
+func TestTokenBucket_Check(t *testing.T) {
+    tb := newTestLimiter(t)
+    ctx := context.Background()
+
+    tb.AllowN(ctx, "test", 7)
 
-    tb := New(storage, config)
-    defer tb.Stop() // Ensure the ticker is stopped for graceful shutdown
+    // A denied check reports the exact time until enough tokens have been refilled
+    checked, err := tb.Check(ctx, "test", 2)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if checked.Allowed || checked.RetryAfter != 24*time.Second {
+        t.Errorf("unexpected result for denied check: %+v", checked)
+    }
+
+    // Checks see tokens refilled since the last decision
+    clocktest.Pin(&tb.now, 12*time.Second)
+    if checked, _ := tb.Check(ctx, "test", 1); !checked.Allowed || checked.Remaining != 0 {
+        t.Errorf("expected the refilled token to be available, got %+v", checked)
+    }
+    if checked, _ := tb.Check(ctx, "test", 2); checked.Allowed || checked.RetryAfter != 12*time.Second {
+        t.Errorf("expected the second token to be 12s away, got %+v", checked)
+    }
+}
+
+func TestTokenBucket_Reserve(t *testing.T) {
+    tb := newTestLimiter(t)
+    ctx := context.Background()
+
+    tb.AllowN(ctx, "test", 6)
+
+    // The bucket goes into debt for tokens it does not hold yet
+    reservation, err := tb.Reserve(ctx, "test", 3)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if !reservation.OK() || reservation.DelayFrom(clocktest.Start) != 24*time.Second {
+        t.Errorf("expected a reservation in 24s, got OK=%v Delay=%s", reservation.OK(), reservation.DelayFrom(clocktest.Start))
+    }
+
+    quota, _ := tb.Quota(ctx, "test")
+    if quota.Remaining != 0 {
+        t.Errorf("expected no tokens while in debt, got %+v", quota)
+    }
+    next, _ := tb.NextAllowed(ctx, "test")
+    if next != 36*time.Second {
+        t.Errorf("expected the debt to delay the next token to 36s, got %s", next)
+    }
+
+    if err := reservation.Cancel(ctx); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    quota, _ = tb.Quota(ctx, "test")
+    if quota.Remaining != 1 {
+        t.Errorf("expected canceled tokens to be put back, got %+v", quota)
+    }
+}
+
+func TestTokenBucket_PerKey(t *testing.T) {
+    tb := newTestLimiter(t)
+    ctx := context.Background()
+
+    if allowed, _ := tb.AllowN(ctx, "user-a", 7); !allowed {
+        t.Fatalf("expected user-a to drain its bucket")
+    }
+    if allowed, _ := tb.Allow(ctx, "user-a"); allowed {
+        t.Errorf("expected user-a to be limited")
+    }
 
     for i := 0; i < 7; i++ {
-        allowed, err := tb.Allow(context.Background(), "test")
-        if err != nil {
-            t.Errorf("unexpected error: %v", err)
+        if allowed, _ := tb.Allow(ctx, "user-b"); !allowed {
+            t.Errorf("request %d of user-b should not be affected by user-a", i+1)
         }
+    }
 
-        if i < 5 && !allowed {
-            t.Errorf("request %d should be allowed", i+1)
-        }
+    quota, _ := tb.Quota(ctx, "user-c")
+    if quota.Remaining != 7 {
+        t.Errorf("expected an unused key to have a full bucket, got %+v", quota)
+    }
+}
 
-        if i >= 5 && allowed {
-            t.Errorf("request %d should be denied", i+1)
-        }
+func TestTokenBucket_Quota(t *testing.T) {
+    tb := newTestLimiter(t)
+
+    for i := 0; i < 3; i++ {
+        tb.Allow(context.Background(), "test")
+    }
+
+    quota, err := tb.Quota(context.Background(), "test")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    if quota.Algorithm != Name || quota.Used != 3 || quota.Limit != 5 || quota.Burst != 2 || quota.Remaining != 4 {
+        t.Errorf("unexpected quota: %+v", quota)
+    }
+
+    if quota.WindowEnd.Sub(quota.WindowStart) != 36*time.Second {
+        t.Errorf("expected the bucket to refill in 36s, got %s to %s", quota.WindowStart, quota.WindowEnd)
+    }
+}
+
+// failingConfig is a policy whose Interval always fails.
+type failingConfig struct {
+    config.Policy
+}
+
+var errInterval = errors.New("interval unavailable")
+
+func (failingConfig) Interval(ctx context.Context) (time.Duration, error) {
+    return 0, errInterval
+}
+
+func TestTokenBucket_IntervalError(t *testing.T) {
+    storage := clocktest.Storage(t)
+
+    tb := New(storage, failingConfig{config.NewStatic(5, time.Minute, 2, 0, time.Now())})
+    if _, err := tb.Allow(context.Background(), "test"); !errors.Is(err, errInterval) {
+        t.Errorf("expected the interval error, got %v", err)
+    }
+
+    tb = New(storage, config.NewStatic(5, 0, 2, 0, time.Now()))
+    if _, err := tb.Allow(context.Background(), "test"); err != ErrInvalidRate {
+        t.Errorf("expected ErrInvalidRate, got %v", err)
+    }
+}
+
+func TestTokenBucket_NoGoroutines(t *testing.T) {
+    storage := clocktest.Storage(t)
+    config := clocktest.Policy()
+
+    before := runtime.NumGoroutine()
+    for i := 0; i < 100; i++ {
+        tb := New(storage, config)
+        tb.Allow(context.Background(), fmt.Sprintf("tenant-%d", i))
+        tb.Stop()
+    }
+    if after := runtime.NumGoroutine(); after > before {
+        t.Errorf("expected no goroutines to be started, went from %d to %d", before, after)
     }
 }